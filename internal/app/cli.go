@@ -3,12 +3,13 @@ package app
 import (
 	"flag"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 
 	file "github.com/redkenrok/go-file_sorter/internal/file"
+	place "github.com/redkenrok/go-file_sorter/internal/place"
+	sorter "github.com/redkenrok/go-file_sorter/internal/sorter"
 )
 
 var (
@@ -17,18 +18,153 @@ var (
 	flagVersion  = flag.Bool("version", false, "Show program version information")
 	versionShort = flag.Bool("v", false, "Show program version information")
 
-	dryRunLong = flag.Bool("dry-run", false, "Perform a dry run without moving or copying files")
-	format     = flag.String("f", "%year%/%year%-%month%-%day%/%type%/file-%hour%_%minute%_%second%-%index%%ext%", "Path format for sorted files")
-	formatLong = flag.String("format", "%year%/%year%-%month%-%day%/%type%/file-%hour%_%minute%_%second%-%index%%ext%", "Path format for sorted files")
-	input      = flag.String("i", ".", "Source directory")
-	inputLong  = flag.String("input", ".", "Source directory")
-	move       = flag.Bool("m", false, "Move files instead of copying")
-	moveLong   = flag.Bool("move", false, "Move files instead of copying")
-	output     = flag.String("o", "", "Destination directory")
-	outputLong = flag.String("output", "", "Destination directory")
-	dryRun     = flag.Bool("dr", false, "Perform a dry run without moving or copying files")
+	dryRunLong  = flag.Bool("dry-run", false, "Perform a dry run without moving or copying files")
+	format      = flag.String("f", FORMAT_PLACEHOLDER, "Path format for sorted files")
+	formatLong  = flag.String("format", FORMAT_PLACEHOLDER, "Path format for sorted files")
+	input       = flag.String("i", ".", "Source directory")
+	inputLong   = flag.String("input", ".", "Source directory")
+	move        = flag.Bool("m", false, "Move files instead of copying")
+	moveLong    = flag.Bool("move", false, "Move files instead of copying")
+	output      = flag.String("o", "", "Destination directory")
+	outputLong  = flag.String("output", "", "Destination directory")
+	dryRun      = flag.Bool("dr", false, "Perform a dry run without moving or copying files")
+	placesPath  = flag.String("places", "", "GeoJSON file with named places")
+	link        = flag.String("link", "", "Link files instead of copying, as hard, symlink or relative")
+	resume      = flag.Bool("resume", false, "Continue the interrupted run in the destination directory")
+	pruneEmpty  = flag.Bool("prune-empty", false, "Remove source directories emptied by moving their files")
+	ignoreSpace = flag.Bool("ignore-space", false, "Continue even when the destination lacks free space")
+
+	duplicates       = flag.String("duplicates", sorter.DUPLICATES_KEEP, "What to do with files whose contents are already sorted")
+	duplicatesReport = flag.String("duplicates-report", "", "File the groups of duplicates are written to")
+
+	sanitizeProfile = flag.String("sanitize", "posix", "File system profile the file names are sanitized for")
+	replacement     = flag.String("replacement", "_", "Replacement for characters not allowed in file names")
+	normalize       = flag.String("normalize", "none", "Unicode normalization of file names")
+
+	collision = flag.String("collision", sorter.COLLISION_RENAME, "What to do when a destination already exists")
+	preserve  = flag.String("preserve", "times,mode", "Metadata kept on copied files")
+	copyMode  = flag.String("copy-mode", file.COPY_MODE_AUTO, "How the contents of files are copied")
+	chmod     = flag.String("chmod", "", "Modes of created files and directories, as 664 or F664,D2775")
+	chown     = flag.String("chown", "", "Owner of created files and directories, as user:group")
+
+	fileTypes = flag.String("type", "", "Only sort files of the categories, mime-types or extensions")
+	minSize   = flag.String("min-size", "", "Only sort files of at least this size")
+	maxSize   = flag.String("max-size", "", "Only sort files of at most this size")
+	since     = flag.String("since", "", "Only sort files created on or after this date")
+	until     = flag.String("until", "", "Only sort files created on or before this date")
+	camera    = flag.String("camera", "", "Only sort files taken by a camera whose make or model contains the text")
+
+	followSymlinks = flag.Bool("follow-symlinks", false, "Walk into symlinked directories and sort the targets of symlinks")
+	hidden         = flag.String("hidden", sorter.HIDDEN_INCLUDE, "Whether hidden files and directories are sorted")
+	maxDepth       = flag.Int("max-depth", 0, "Number of directory levels below the source directory that are walked")
+	oneFileSystem  = flag.Bool("one-file-system", false, "Do not walk into directories on other file systems")
+
+	formatRules formatRulesFlag
+	include     patternsFlag
+	exclude     patternsFlag
 )
 
+func init() {
+	flag.Var(&formatRules, "format-for", "Path format for files matching a category, mime-type or extension, as pattern=format (repeatable)")
+	flag.Var(&include, "include", "Only sort files matching the glob patterns (repeatable)")
+	flag.Var(&exclude, "exclude", "Leave out files and directories matching the glob patterns (repeatable)")
+}
+
+// Collects the repeatable --format-for flag.
+type formatRulesFlag []file.FormatRule
+
+func (
+	rules *formatRulesFlag,
+) String() string {
+	values := make([]string, len(*rules))
+	for i, rule := range *rules {
+		values[i] = rule.Pattern + "=" + rule.Template.String()
+	}
+	return strings.Join(values, ", ")
+}
+
+func (
+	rules *formatRulesFlag,
+) Set(
+	value string,
+) error {
+	rule, err := file.ParseFormatRule(value)
+	if err != nil {
+		return err
+	}
+	*rules = append(*rules, rule)
+	return nil
+}
+
+// Collects the repeatable --include and --exclude flags, each holding one or
+// more comma separated patterns.
+type patternsFlag []sorter.PathPattern
+
+func (
+	patterns *patternsFlag,
+) String() string {
+	values := make([]string, len(*patterns))
+	for i, pattern := range *patterns {
+		values[i] = pattern.String()
+	}
+	return strings.Join(values, ",")
+}
+
+func (
+	patterns *patternsFlag,
+) Set(
+	value string,
+) error {
+	parsed, err := sorter.ParsePathPatterns(value)
+	if err != nil {
+		return err
+	}
+	*patterns = append(*patterns, parsed...)
+	return nil
+}
+
+// The verb and its past tense describing each transfer mode.
+var transferVerbs = map[string][2]string{
+	sorter.TRANSFER_COPY:             {"copy", "Copied"},
+	sorter.TRANSFER_MOVE:             {"move", "Moved"},
+	sorter.TRANSFER_HARDLINK:         {"hardlink", "Hardlinked"},
+	sorter.TRANSFER_SYMLINK:          {"symlink", "Symlinked"},
+	sorter.TRANSFER_SYMLINK_RELATIVE: {"symlink", "Symlinked"},
+}
+
+var placeholders = [][2]string{
+	{"%year%", "4-digit year"},
+	{"%month%", "2-digit month"},
+	{"%day%", "2-digit day"},
+	{"%hour%", "2-digit hour (24-hour format)"},
+	{"%minute%", "2-digit minute"},
+	{"%second%", "2-digit second"},
+	{"%index%", "Incremental file index"},
+	{"%ext%", "File extension"},
+	{"%type%", "File type (flac,svg+xml,webm)"},
+	{"%mime-type%", "File's mime-type (audio/flac,image/svg+xml,video/webm)"},
+	{"%place%", "Name of the place the photo was taken, empty when none matches"},
+	{"%camera-make%", "Camera manufacturer from the EXIF data"},
+	{"%camera-model%", "Camera model from the EXIF data"},
+	{"%name%", "Original file name without extension"},
+	{"%parent%", "Name of the directory the file is in"},
+	{"%hash%", "Hex digest of the contents, optionally with algorithm and length (%hash:sha256:12%)"},
+	{"%size%", "File size in bytes"},
+	{"%size-human%", "File size in a readable form (4.2MB)"},
+	{"%size-bucket%", "Name of the size bucket, optionally with thresholds (%size-bucket:small<1MB<medium<100MB<large%)"},
+}
+
+var filters = [][2]string{
+	{"lower", "Lower case"},
+	{"upper", "Upper case"},
+	{"trim", "Remove surrounding whitespace"},
+	{"slug", "Replace runs of other characters than letters and digits by a dash"},
+	{"truncate:N", "Keep the first N characters"},
+	{"pad:N", "Pad with zeros to N characters"},
+	{"default:VALUE", "Use the value when empty"},
+	{"replace:OLD:NEW", "Replace every occurrence of OLD by NEW"},
+}
+
 func RunCLI(
 	version string,
 	commit string,
@@ -52,7 +188,7 @@ func RunCLI(
 		destDir = *outputLong
 	}
 	if destDir == "" {
-		fmt.Println("Error: Output directory is required\n")
+		fmt.Print("Error: Output directory is required\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -69,106 +205,581 @@ func RunCLI(
 		pathFormat = *formatLong
 	}
 
+	template, err := file.CompileFormat(pathFormat)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	sanitizer, err := file.NewSanitizer(*sanitizeProfile, *replacement, *normalize)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	collisionPolicy, err := sorter.ParseCollisionPolicy(*collision)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	preservation, err := file.ParsePreservation(*preserve)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fileCopyMode, err := file.ParseCopyMode(*copyMode)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	duplicatePolicy, err := sorter.ParseDuplicatePolicy(*duplicates)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	hiddenPolicy, err := sorter.ParseHiddenPolicy(*hidden)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *maxDepth < 0 {
+		fmt.Println("Error: --max-depth can not be negative")
+		os.Exit(1)
+	}
+
+	metadataFilter, err := sorter.ParseMetadataFilter(*fileTypes, *minSize, *maxSize, *since, *until, *camera)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var permissions file.Permissions
+	if err := file.ParseChmod(*chmod, &permissions); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := file.ParseChown(*chown, &permissions); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	doDryRun := *dryRun || *dryRunLong
-	doMove := *move || *moveLong
+	transferMode := sorter.TRANSFER_COPY
+	if *move || *moveLong {
+		transferMode = sorter.TRANSFER_MOVE
+	}
+	if *link != "" {
+		if transferMode == sorter.TRANSFER_MOVE {
+			fmt.Println("Error: --move and --link can not be combined")
+			os.Exit(1)
+		}
+		transferMode, err = sorter.ParseLinkType(*link)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var places place.Places
+	if *placesPath != "" {
+		places, err = place.LoadPlaces(*placesPath)
+		if err != nil {
+			fmt.Printf("Error loading places: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+	// Dry runs leave the permissions of the destination alone.
+	destPermissions := permissions
+	if doDryRun {
+		destPermissions = file.Permissions{}
+	}
+	createdDirectories, err := sorter.CreateDirectories(destDir, destPermissions)
+	if err != nil {
 		fmt.Printf("Error creating destination directory: %v\n", err)
 		os.Exit(1)
 	}
 
-	count := 0
-	err := filepath.Walk(
-		sourceDir,
-		func(
-			path string,
-			info fs.FileInfo,
-			err error,
-		) error {
-			if err != nil {
-				return err
-			}
+	options := sorter.Options{
+		SourceDir: sourceDir,
+		DestDir:   destDir,
+		Format:    template,
+		Transfer:  transferMode,
+		DryRun:    doDryRun,
 
-			if info.IsDir() {
-				return nil
-			}
+		FormatRules: formatRules,
+		Sanitizer:   sanitizer,
+		Places:      places,
+		Collision:   collisionPolicy,
+		Preserve:    preservation,
+		CopyMode:    fileCopyMode,
+		Duplicates:  duplicatePolicy,
+		Permissions: permissions,
+		Include:     include,
+		Exclude:     exclude,
+		Filter:      metadataFilter,
 
-			// Skip files in destination directory.
-			absPath, _ := filepath.Abs(path)
-			if strings.HasPrefix(absPath, destDir) {
-				return nil
-			}
+		FollowSymlinks: *followSymlinks,
+		Hidden:         hiddenPolicy,
+		MaxDepth:       *maxDepth,
+		OneFileSystem:  *oneFileSystem,
+	}
 
-			// Create new file path using creation date.
-			creationDate, err := file.GetFileCreationDate(path)
-			if err != nil {
-				return fmt.Errorf("error getting creation date for file %s: %w", path, err)
-			}
-			count++
-			newFileName := file.FormatName(pathFormat, creationDate, count, path)
-			destinationPath := filepath.Join(destDir, newFileName)
-
-			if doDryRun {
-				fmt.Printf("Dry run: Would %s file %s to %s\n",
-					map[bool]string{true: "move", false: "copy"}[doMove],
-					path, destinationPath)
-				return nil
-			}
+	run := sorter.NewRun(options)
+	run.SetCreatedDirectories(createdDirectories)
+	var items []sorter.Item
+	if *resume {
+		if doDryRun {
+			fmt.Println("Error: --resume can not be combined with --dry-run")
+			os.Exit(1)
+		}
 
-			if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(destinationPath), err)
-			}
+		var journalPath string
+		items, journalPath, err = run.Resume()
+		if err != nil {
+			fmt.Printf("Error resuming: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Resuming the run of journal %s, %d files already done.\n", journalPath, run.Summary.Resumed)
+	} else {
+		if !doDryRun && sorter.HasState(destDir) {
+			fmt.Println("Warning: starting over an interrupted run, use --resume to continue it instead.")
+		}
+
+		items, run.Summary.Filtered, err = sorter.Plan(options)
+		if err != nil {
+			fmt.Printf("Error processing files: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := sorter.CheckHardlinks(items, options); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		sorter.RemoveCreatedDirectories([]sorter.JournalEntry{{Directories: createdDirectories}})
+		os.Exit(1)
+	}
+
+	// Duplicates are found first so the space check leaves out the skipped ones.
+	var duplicateGroups []sorter.DuplicateGroup
+	if duplicatePolicy != sorter.DUPLICATES_KEEP {
+		duplicateGroups, err = sorter.FindDuplicates(items, options)
+		if err != nil {
+			fmt.Printf("Error finding duplicates: %v\n", err)
+			os.Exit(1)
+		}
+		run.SetDuplicates(duplicateGroups)
+		fmt.Printf("Found %d groups of duplicates.\n", len(duplicateGroups))
+	}
+
+	if !doDryRun {
+		removed, err := run.RemoveTemporaryFiles(items)
+		if err != nil {
+			fmt.Printf("Warning: failed to remove temporary files: %v\n", err)
+		}
+		if removed > 0 {
+			fmt.Printf("Removed %d temporary files left by an interrupted run.\n", removed)
+		}
+	}
 
-			// Choose between move and copy.
-			var transferErr error
-			if doMove {
-				transferErr = os.Rename(path, destinationPath)
+	// Check the free space before anything is written to the destination.
+	space, err := run.CheckSpace(items)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	} else if !space.Sufficient() {
+		if doDryRun || *ignoreSpace {
+			fmt.Printf("Warning: not enough free space in %s, %s.\n", destDir, space)
+		} else {
+			fmt.Printf("Error: not enough free space in %s, %s. Use --ignore-space to continue anyway.\n", destDir, space)
+			run.Close()
+			os.Exit(1)
+		}
+	}
+
+	if !*resume && !doDryRun {
+		journalPath, err := run.Start(items)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Writing journal to %s\n", journalPath)
+	}
+
+	for _, item := range items {
+		result, err := run.Process(item)
+		if err != nil {
+			fmt.Printf("Error processing files: failed to %s file %s: %v\n",
+				transferVerbs[transferMode][0], item.Path, err)
+			run.Close()
+			os.Exit(1)
+		}
+		if result.Outcome == sorter.OUTCOME_FAILED {
+			fmt.Printf("Failed to %s file %s, it is left in place: %v\n",
+				transferVerbs[transferMode][0], item.Path, result.Failure)
+			continue
+		}
+
+		if doDryRun {
+			fmt.Print("Dry run: Would ")
+			if result.Outcome == sorter.OUTCOME_SKIPPED {
+				fmt.Print("skip")
+			} else if result.Link != "" {
+				fmt.Print(transferVerbs[sorter.TRANSFER_HARDLINK][0])
+			} else if result.Copied {
+				fmt.Print(transferVerbs[sorter.TRANSFER_COPY][0])
 			} else {
-				transferErr = file.CopyFile(path, destinationPath)
+				fmt.Print(transferVerbs[transferMode][0])
 			}
+		} else if result.Outcome == sorter.OUTCOME_SKIPPED {
+			fmt.Print("Skipped")
+		} else if result.Link != "" {
+			fmt.Print(transferVerbs[sorter.TRANSFER_HARDLINK][1])
+		} else if result.Copied {
+			fmt.Print(transferVerbs[sorter.TRANSFER_COPY][1])
+		} else {
+			fmt.Print(transferVerbs[transferMode][1])
+		}
+		if result.Outcome == sorter.OUTCOME_SKIPPED && result.Duplicate != "" {
+			fmt.Printf(" duplicate file %s of %s\n", result.Item.Path, result.Duplicate)
+			continue
+		}
+		if result.Outcome == sorter.OUTCOME_SKIPPED {
+			fmt.Printf(" file %s, %s already exists\n", result.Item.Path, result.Item.DestinationPath)
+			continue
+		}
+		fmt.Printf(" file %s to %s", result.Item.Path, result.Item.DestinationPath)
+		switch result.Outcome {
+		case sorter.OUTCOME_RENAMED:
+			fmt.Print(", renamed as destination exists")
+		case sorter.OUTCOME_OVERWRITTEN:
+			fmt.Print(", overwriting existing file")
+		}
+		if result.CrossDevice {
+			fmt.Print(", copied to the other file system")
+		}
+		if result.Copied {
+			fmt.Print(", the symlink is left in place")
+		}
+		if result.Link != "" {
+			fmt.Printf(", duplicate linked to %s", result.Link)
+		} else if result.Duplicate != "" {
+			fmt.Printf(", duplicate of %s", result.Duplicate)
+		}
+		fmt.Println()
+	}
+
+	if err := run.Finish(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *pruneEmpty {
+		for _, directory := range run.PruneEmpty() {
+			fmt.Printf("Removed empty directory %s\n", directory)
+		}
+	}
+	showSummary(run.Summary)
+
+	if len(duplicateGroups) > 0 {
+		if err := writeDuplicateReport(duplicateGroups); err != nil {
+			fmt.Printf("Error writing duplicate report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// Writes the duplicate report to the file given by --duplicates-report, or
+// prints it when none is given.
+func writeDuplicateReport(
+	groups []sorter.DuplicateGroup,
+) error {
+	if *duplicatesReport == "" {
+		fmt.Println("\nDuplicates:")
+		return sorter.WriteDuplicateReport(os.Stdout, groups)
+	}
+
+	reportFile, err := os.Create(*duplicatesReport)
+	if err != nil {
+		return err
+	}
+	defer reportFile.Close()
+	if err := sorter.WriteDuplicateReport(reportFile, groups); err != nil {
+		return err
+	}
+	fmt.Printf("Duplicate report written to %s\n", *duplicatesReport)
+	return reportFile.Close()
+}
+
+// Reverses the operations recorded in a journal, newest first.
+func RunUndo(
+	arguments []string,
+) {
+	if len(arguments) != 1 {
+		fmt.Println("Usage: file_sorter undo <journal>")
+		os.Exit(1)
+	}
+
+	// The state of the run refers to its journal by absolute path.
+	journalPath, err := filepath.Abs(arguments[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	entries, err := sorter.ReadJournal(journalPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-			if transferErr != nil {
-				return fmt.Errorf("failed to %s file %s to %s: %w",
-					map[bool]string{true: "move", false: "copy"}[doMove],
-					path, destinationPath, transferErr)
+	failed := 0
+	total := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Action == sorter.ACTION_CREATE_DIRECTORIES {
+			continue
+		}
+		// Operations interrupted before they were recorded as done are only
+		// undone when they took effect.
+		if entry.Pending {
+			settled, ok := sorter.SettleEntry(entry)
+			if !ok {
+				continue
 			}
+			entry = settled
+		}
+		total++
+		if err := sorter.UndoEntry(entry); err != nil {
+			fmt.Printf("Error undoing %s of %s: %v\n", entry.Action, entry.Source, err)
+			failed++
+			continue
+		}
 
-			fmt.Printf("%s file %s to %s\n",
-				map[bool]string{true: "Moved", false: "Copied"}[doMove],
-				path, destinationPath)
-			return nil
-		},
-	)
+		if entry.Action == sorter.TRANSFER_MOVE {
+			fmt.Printf("Moved file %s back to %s\n", entry.Destination, entry.Source)
+		} else {
+			fmt.Printf("Removed file %s\n", entry.Destination)
+		}
+		if entry.Outcome == sorter.OUTCOME_OVERWRITTEN {
+			fmt.Printf("Warning: the file %s replaced could not be restored\n", entry.Destination)
+		}
+	}
 
+	// The journal is kept until every operation is undone, so undo can be run
+	// again for the rest.
+	if failed == 0 {
+		if err := sorter.RemoveJournal(journalPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	removed := sorter.RemoveCreatedDirectories(entries)
+	fmt.Printf("\nUndid %d of %d operations, removed %d directories.\n", total-failed, total, len(removed))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Groups the images in a directory that look alike, and optionally keeps only
+// the one with the highest resolution of each group.
+func RunDedupe(
+	arguments []string,
+) {
+	flags := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	algorithm := flags.String("algorithm", file.PERCEPTUAL_DHASH, "Perceptual hash algorithm, dhash or phash")
+	threshold := flags.Int("threshold", sorter.PERCEPTUAL_THRESHOLD_DEFAULT, "Maximum number of differing bits of near-duplicates")
+	keepBest := flags.Bool("keep-best", false, "Move all but the highest resolution image of each group aside")
+	flags.Usage = func() {
+		fmt.Println("Usage: file_sorter dedupe [options] <directory>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	directory, _ := filepath.Abs(flags.Arg(0))
+
+	perceptualAlgorithm, err := file.ParsePerceptualAlgorithm(*algorithm)
 	if err != nil {
-		fmt.Printf("Error processing files: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *threshold < 0 || *threshold > 64 {
+		fmt.Println("Error: the threshold has to be between 0 and 64")
+		os.Exit(1)
+	}
+
+	groups, undecodable, err := sorter.FindSimilarImages(directory, perceptualAlgorithm, *threshold)
+	if err != nil {
+		fmt.Printf("Error finding near-duplicates: %v\n", err)
+		os.Exit(1)
+	}
+	for _, path := range undecodable {
+		fmt.Printf("Warning: could not decode image %s\n", path)
+	}
+
+	for _, group := range groups {
+		best := group.Images[0]
+		fmt.Printf("%s (%dx%d)\n", best.Path, best.Width, best.Height)
+		for _, similarImage := range group.Images[1:] {
+			fmt.Printf("  %s (%dx%d, distance %d)\n", similarImage.Path, similarImage.Width, similarImage.Height,
+				file.HammingDistance(best.Hash, similarImage.Hash))
+		}
+
+		if *keepBest {
+			moved, err := sorter.KeepBest(group, directory)
+			for _, path := range moved {
+				fmt.Printf("  Moved aside to %s\n", path)
+			}
+			if err != nil {
+				fmt.Printf("Error moving near-duplicates: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	fmt.Printf("\nFound %d groups of near-duplicates.\n", len(groups))
+}
+
+func showSummary(
+	summary sorter.Summary,
+) {
+	fmt.Printf("\nProcessed %d files, transferred %d.\n", summary.Processed, summary.Transferred)
+	if summary.Filtered > 0 {
+		fmt.Printf("Filtered: %d files not matching the filters.\n", summary.Filtered)
+	}
+	if summary.Duplicates > 0 {
+		fmt.Printf("Duplicates: %d.\n", summary.Duplicates)
+	}
+	if summary.Linked > 0 {
+		fmt.Printf("Linked: %d duplicates to their original, their sources were left in place.\n", summary.Linked)
+	}
+	if summary.Resumed > 0 {
+		fmt.Printf("Resumed after %d files done by the interrupted run.\n", summary.Resumed)
+	}
+	if summary.Collisions > 0 {
+		fmt.Printf("Collisions: %d (%d renamed, %d overwritten, %d skipped).\n",
+			summary.Collisions, summary.Renamed, summary.Overwritten, summary.Skipped)
+	}
+	if summary.MovedByCopy > 0 {
+		fmt.Printf("Moved %d files by rename and %d by copying them to another file system.\n",
+			summary.MovedByRename, summary.MovedByCopy)
+	}
+	if summary.Failed > 0 {
+		fmt.Printf("Failed: %d files whose copies could not be verified were left in place.\n", summary.Failed)
 	}
 }
 
 func showHelp() {
 	fmt.Println("file_sorter: Organize and sort files based on its metadata.")
 	fmt.Println("\nUsage: file_sorter [options]")
+	fmt.Println("       file_sorter undo <journal>")
+	fmt.Println("       file_sorter dedupe [--algorithm dhash|phash] [--threshold N] [--keep-best] <directory>")
 	fmt.Println("\nOptions:")
+	fmt.Println("  --camera         Only sort files taken by a camera whose make or model contains any of the comma separated texts, ignoring case.")
+	fmt.Println("  --chmod          Octal mode of created files and directories, as 664 for both or F664,D2775 for each separately. Links are left as is.")
+	fmt.Println("  --chown          Owner of created files and directories, as user:group, user or :group, by name or id.")
+	fmt.Printf("  --collision      What to do when a destination exists: %s (default: rename).\n", strings.Join(sorter.GetCollisionPolicies(), ", "))
+	fmt.Printf("  --copy-mode      How file contents are copied: %s (default: auto). Auto clones where possible, then copies in the kernel, then in user space.\n", strings.Join(file.GetCopyModes(), ", "))
 	fmt.Println("  -dr, --dry-run   Perform a dry run without actually moving or copying files, simply outputs what it would have done.")
-	fmt.Println("  -f, --format     File path format (default: %year%/%year%-%month%-%day%/%type%/file-%hour%_%minute%_%second%-%index%%ext%).")
+	fmt.Printf("  --duplicates     What to do with files whose contents are already in the output or earlier in the run: %s (default: keep).\n", strings.Join(sorter.GetDuplicatePolicies(), ", "))
+	fmt.Println("  --duplicates-report File the groups of duplicates are written to, printed after the run by default.")
+	fmt.Println("  --exclude        Leave out files and directories matching the glob patterns, comma separated. Repeatable.")
+	fmt.Println("  --follow-symlinks Walk into symlinked directories and sort the targets of symlinks, every directory is walked once so cycles end. Symlinked files are named after their target, when moving the target is copied and the link is left in place.")
+	fmt.Printf("  -f, --format     File path format (default: %s).\n", FORMAT_PLACEHOLDER)
+	fmt.Println("  --format-for     Format for files matching a pattern, as pattern=format. Repeatable, the most specific pattern wins.")
 	fmt.Println("  -h, --help       Show detailed help information.")
+	fmt.Printf("  --hidden         Whether files and directories starting with a dot are sorted: %s (default: include).\n", strings.Join(sorter.GetHiddenPolicies(), ", "))
+	fmt.Println("  --ignore-space   Continue when the output seems to lack the free space the run needs, instead of stopping.")
+	fmt.Println("  --include        Only sort files matching any of the glob patterns, comma separated. Repeatable.")
 	fmt.Println("  -i, --input      Input directory (default: current working directory).")
+	fmt.Println("  --normalize      Unicode normalization of file names: none (default), nfc or nfd.")
+	fmt.Println("  --link           Link files instead of copying them: hard for hardlinks on the same file system, symlink for absolute or relative for relative symlinks.")
+	fmt.Println("  --max-depth      Number of directory levels walked, 1 only sorts the files directly in the input directory (default: 0, no limit).")
+	fmt.Println("  --max-size       Only sort files of at most this size, as 500KB or 2GiB.")
+	fmt.Println("  --min-size       Only sort files of at least this size, as 500KB or 2GiB.")
 	fmt.Println("  -m, --move       Move files instead of copying, increased performance when on the same disk.")
+	fmt.Println("  --one-file-system Do not walk into directories on other file systems than the input directory, such as mount points.")
 	fmt.Println("  -o, --output     Output directory (required).")
+	fmt.Println("  --places         GeoJSON file of named places, used by the place placeholder.")
+	fmt.Printf("  --preserve       Metadata kept on copied files, any of %s (default: times,mode). Owner only applies when running as root, xattr only on Linux.\n", strings.Join(file.GetPreserveAttributes(), ","))
+	fmt.Println("  --prune-empty    Remove source directories emptied by moving their files, deepest first. Directories with files left in them are kept.")
+	fmt.Println("  --replacement    Replacement for characters not allowed in file names (default: _).")
+	fmt.Println("  --resume         Continue the interrupted run in the output directory, skipping the files it finished. Needs the same format, naming, collision and transfer options.")
+	fmt.Println("  --since          Only sort files created on or after the date, as 2024, 2024-03, 2024-03-15 or 2024-03-15T10:30:00.")
+	fmt.Printf("  --sanitize       File system the file names are made valid for: %s (default: posix).\n", strings.Join(file.GetSanitizeProfiles(), ", "))
+	fmt.Printf("  --type           Only sort files of any of the comma separated categories (%s), mime-types (image/*) or extensions (.pdf).\n", strings.Join(file.GetCategories(), ", "))
+	fmt.Println("  --until          Only sort files created on or before the date, including the whole day, month or year given.")
 	fmt.Println("  -v, --version    Show program version information.")
 	fmt.Println("\nFormat Placeholders:")
-	fmt.Println("  %year%      - 4-digit year")
-	fmt.Println("  %month%     - 2-digit month")
-	fmt.Println("  %day%       - 2-digit day")
-	fmt.Println("  %hour%      - 2-digit hour (24-hour format)")
-	fmt.Println("  %minute%    - 2-digit minute")
-	fmt.Println("  %second%    - 2-digit second")
-	fmt.Println("  %index%     - Incremental file index")
-	fmt.Println("  %ext%       - File extension")
-	fmt.Println("  %type%      - File type (flac,svg+xml,webm)")
-	fmt.Println("  %mime-type% - File's mime-type (audio/flac,image/svg+xml,video/webm)")
+	for _, placeholder := range placeholders {
+		fmt.Printf("  %-14s - %s\n", placeholder[0], placeholder[1])
+	}
+	fmt.Println("\n  A literal percent sign is written as %%. Unknown placeholders are rejected.")
+	fmt.Println("  Path segments that render empty are dropped.")
+	fmt.Println("\nPlaces:")
+	fmt.Println("  A GeoJSON feature collection where every feature has a \"name\" property.")
+	fmt.Println("  Points need a \"radius\" property in meters, polygons are used as is.")
+	fmt.Println("  Overlapping places are resolved by the highest \"priority\" property.")
+	fmt.Println("\nFormat Filters:")
+	fmt.Printf("  Placeholder values can be passed through filters, as in %s.\n", "%camera-model|lower|slug%")
+	for _, filter := range filters {
+		fmt.Printf("  %-15s - %s\n", filter[0], filter[1])
+	}
+	fmt.Printf("\n  Empty values can fall back to a default, as in %s.\n", "%place|default:unknown-place%")
+	fmt.Println("  Filter arguments can not contain path separators.")
+	fmt.Println("\nCollision policies:")
+	fmt.Println("  rename            - Add a -1, -2, ... suffix until the destination is free")
+	fmt.Println("  skip              - Leave the file where it is")
+	fmt.Println("  skip-if-identical - Skip when the contents are the same, rename otherwise")
+	fmt.Println("  keep-newer        - Overwrite when the file is newer than the destination, skip otherwise")
+	fmt.Println("  overwrite         - Replace the destination")
+	fmt.Println("  fail              - Stop with an error")
+	fmt.Println("\nDuplicate policies:")
+	fmt.Println("  keep - Sort duplicates like any other file, without looking for them")
+	fmt.Println("  skip - Leave duplicates where they are")
+	fmt.Println("  link - Hardlink the destination of a duplicate to its original, a moved duplicate is left in place and counted as linked")
+	fmt.Printf("  move - Sort duplicates into the %s directory of the output\n", sorter.DUPLICATES_DIRECTORY)
+	fmt.Println("  Files are compared by size, then by the hash of their start, then by the hash of their contents.")
+	fmt.Println("\nNear-duplicates:")
+	fmt.Println("  `file_sorter dedupe <directory>` groups JPEG, PNG and GIF images that look alike, such as resized or")
+	fmt.Println("  re-encoded copies, by comparing their perceptual hashes. The threshold is the number of hash bits")
+	fmt.Printf("  that may differ (default: %d). With --keep-best all but the highest resolution image of each group\n", sorter.PERCEPTUAL_THRESHOLD_DEFAULT)
+	fmt.Printf("  are moved to the %s directory.\n", sorter.NEAR_DUPLICATES_DIRECTORY)
+	fmt.Println("\nFilters:")
+	fmt.Println("  Patterns are globs relative to the input directory, where * and ? do not match a slash and ** matches")
+	fmt.Println("  any number of directories, as in photos/**/*.jpg. Patterns without a slash match the name at any depth")
+	fmt.Println("  and a trailing slash only matches directories. Excluded directories are not scanned at all.")
+	fmt.Printf("  A %s file in any directory of the input lists patterns to leave out, relative to that\n", sorter.IGNORE_FILE)
+	fmt.Println("  directory, in gitignore syntax: # starts a comment and ! includes an excluded path again.")
+	fmt.Println("  The metadata filters --type, --min-size, --max-size, --since, --until and --camera are applied after")
+	fmt.Println("  reading the dates and EXIF data, files not matching are counted as filtered in the summary.")
+	fmt.Println("\nFree space:")
+	fmt.Println("  Before the first write the size of the files is compared with the free space of the output, plus a")
+	fmt.Println("  margin of 4 KB per file and 5% on top. Links, moves within the same file system and files skipped as")
+	fmt.Println("  duplicates or because their destination exists take no space.")
+	fmt.Println("\nJournal and undo:")
+	fmt.Printf("  Every run writes a journal of its operations to %s/journal-<time>.jsonl in the output directory.\n", sorter.JOURNAL_DIRECTORY)
+	fmt.Println("  Run `file_sorter undo <journal>` to move the files back and remove the copies and links it made.")
+	fmt.Println("  Copies are only removed when their hash still matches, and created directories only when empty.")
+	fmt.Println("\nTemplate mode:")
+	fmt.Printf("  Formats starting with %q are Go text/template templates, the extension is not added.\n", file.TEMPLATE_PREFIX)
+	fmt.Println("  Fields:    .Path .Name .Ext .Parent .CreationDate .Index .MimeType .Type .Size .SizeHuman")
+	fmt.Println("             .Place .CameraMake .CameraModel .Exif.DateTime .Exif.Latitude .Exif.Longitude")
+	fmt.Println("  Methods:   .Hash \"sha256\" 12, .SizeBucket \"small<1MB<large\"")
+	fmt.Println("  Functions: lower, upper, trim, slug, truncate N, pad N, default VALUE, replace OLD NEW, date LAYOUT")
+	fmt.Println("  Example:   tmpl:{{if .CameraModel}}{{.CreationDate.Year}}/{{.CameraModel | slug}}{{else}}unsorted{{end}}/{{.Name}}{{.Ext}}")
+	fmt.Println("\nFormat patterns:")
+	fmt.Println("  Extension:  .pdf, .jpg")
+	fmt.Println("  Mime-type:  application/pdf, image/*")
+	fmt.Printf("  Category:   %s\n", strings.Join(file.GetCategories(), ", "))
+	fmt.Println("\nHash algorithms:")
+	fmt.Println("  md5, sha1, sha256 (default), sha512, blake3, xxh64")
 	fmt.Println("\nExample:")
-	fmt.Println("  file_sorter -i /source -o /destination -f \"%year%/%year%-%month%-%day%/file-%hour%_%minute%-%index%%ext%\"")
+	fmt.Printf("  file_sorter -i /source -o /destination -f \"%s\"\n", "%year%/%year%-%month%-%day%/file-%hour%_%minute%-%index%%ext%")
 }
 
 func showVersion(
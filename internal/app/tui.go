@@ -3,7 +3,6 @@ package app
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/filepicker"
@@ -13,6 +12,8 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	file "github.com/redkenrok/go-file_sorter/internal/file"
+	place "github.com/redkenrok/go-file_sorter/internal/place"
+	sorter "github.com/redkenrok/go-file_sorter/internal/sorter"
 )
 
 const BUFFER_HEIGHT = 10
@@ -93,11 +94,29 @@ const (
 	stateSourcePicker state = iota
 	stateDestPicker
 	stateFormatInput
+	stateFormatRulesInput
+	statePlacesInput
+	statePlanning
 	stateConfirm
 	stateProcessing
 	stateFinished
 )
 
+// The options that can be changed on the confirm screen.
+const (
+	confirmDryRun = iota
+	confirmTransfer
+	confirmPreserve
+	confirmPruneEmpty
+	confirmSanitize
+	confirmNormalize
+	confirmCollision
+	confirmDuplicates
+	confirmInclude
+	confirmExclude
+	confirmOptionCount
+)
+
 type model struct {
 	version   string
 	commit    string
@@ -114,23 +133,58 @@ type model struct {
 
 	state        state
 	formatInput  textinput.Model
+	placesInput  textinput.Model
 	confirmIndex int
 
+	format           *file.Template
+	formatRulesInput textinput.Model
+	formatRules      []file.FormatRule
+
+	places place.Places
+	items  []sorter.Item
+
+	// Items as planned, before the include and exclude patterns.
+	planned      []sorter.Item
+	includeInput textinput.Model
+	excludeInput textinput.Model
+
 	currentOperation string
 	dryRun           bool
-	moveMode         bool
+	transferMode     int
+	preserve         bool
+	pruneEmpty       bool
+	pruned           int
+	spaceWarned      bool
+	sanitizeProfile  int
+	normalization    int
+	collision        int
+	duplicates       int
+	duplicateGroups  int
+	run              *sorter.Run
+	journalPath      string
 	processed        int
 	total            int
 	lastProcessed    []fileRecord
 }
 
+type filesPlanned struct {
+	items []sorter.Item
+	error error
+}
+
 type processingStarted struct {
-	totalFiles int
+	totalFiles      int
+	journalPath     string
+	duplicateGroups int
 }
 
 type fileProcessed struct {
 	path            string
 	destinationPath string
+	outcome         string
+	crossDevice     bool
+	linked          bool
+	copied          bool
 	error           error
 }
 
@@ -156,6 +210,24 @@ func initialModel(
 	fi.Placeholder = FORMAT_PLACEHOLDER
 	fi.Width = 80
 
+	ri := textinput.New()
+	ri.Placeholder = "image/*=%year%/%month%/%day%/file-%index%"
+	ri.Width = 80
+
+	pi := textinput.New()
+	pi.Placeholder = "No places file"
+	pi.Width = 80
+
+	ii := textinput.New()
+	ii.Prompt = ""
+	ii.Placeholder = "all files, as photos/**/*.jpg"
+	ii.Width = 60
+
+	ei := textinput.New()
+	ei.Prompt = ""
+	ei.Placeholder = "nothing, as .thumbnails/,*.tmp"
+	ei.Width = 60
+
 	return model{
 		version:   version,
 		commit:    commit,
@@ -169,6 +241,12 @@ func initialModel(
 
 		state:       stateSourcePicker,
 		formatInput: fi,
+		placesInput: pi,
+
+		formatRulesInput: ri,
+		includeInput:     ii,
+		excludeInput:     ei,
+		preserve:         true,
 
 		sourcePicker: sp,
 	}
@@ -195,14 +273,32 @@ func (
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
+	case filesPlanned:
+		// Planning mostly fails on the paths the format renders, so the format
+		// can be changed before trying again.
+		if msg.error != nil {
+			m.err = msg.error
+			m.state = stateFormatInput
+			m.placesInput.Blur()
+			m.formatInput.Focus()
+			return m, textinput.Blink
+		}
+		m.planned = msg.items
+		m.filterItems()
+		m.state = stateConfirm
+		return m, nil
+
 	case processingStarted:
 		m.total = msg.totalFiles
+		m.journalPath = msg.journalPath
+		m.duplicateGroups = msg.duplicateGroups
 		m.processed = 0
 		return m, m.processNextFile(0)
 
 	case fileProcessed:
 		if msg.error != nil {
 			m.err = msg.error
+			m.run.Close()
 			return m, tea.Quit
 		}
 
@@ -211,15 +307,28 @@ func (
 		action := ""
 		if m.dryRun {
 			action += "Would "
-			if m.moveMode {
-				action += "move"
-			} else {
+			if msg.outcome == sorter.OUTCOME_SKIPPED {
+				action += "skip"
+			} else if msg.linked {
+				action += "hardlink"
+			} else if msg.copied {
 				action += "copy"
+			} else {
+				action += m.transferVerb()
 			}
-		} else if m.moveMode {
-			action = "Move"
-		} else {
+		} else if msg.outcome == sorter.OUTCOME_SKIPPED {
+			action = "Skip"
+		} else if msg.outcome == sorter.OUTCOME_FAILED {
+			action = "Keep unverified"
+		} else if msg.linked {
+			action = "Hardlink"
+		} else if msg.copied {
 			action = "Copy"
+		} else if msg.crossDevice {
+			action = "Move by copy"
+		} else {
+			verb := m.transferVerb()
+			action = strings.ToUpper(verb[:1]) + verb[1:]
 		}
 
 		if msg.path != "" {
@@ -249,6 +358,12 @@ func (
 
 	case processingFinished:
 		if m.processed == m.total {
+			if err := m.run.Finish(); err != nil {
+				m.err = err
+			}
+			if m.pruneEmpty {
+				m.pruned = len(m.run.PruneEmpty())
+			}
 			m.state = stateFinished
 			return m, nil
 		}
@@ -265,7 +380,7 @@ func (
 		case key.Matches(msg, m.keys.Quit):
 			return m, tea.Quit
 
-		case key.Matches(msg, m.keys.Help):
+		case key.Matches(msg, m.keys.Help) && m.confirmInput() == nil:
 			m.help.ShowAll = !m.help.ShowAll
 		}
 
@@ -294,28 +409,126 @@ func (
 
 		case stateFormatInput:
 			if key.Matches(msg, m.keys.Enter) {
-				m.state = stateConfirm
+				format := m.formatInput.Value()
+				if format == "" {
+					format = m.formatInput.Placeholder
+				}
+				template, err := file.CompileFormat(format)
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.err = nil
+				m.format = template
+
+				m.state = stateFormatRulesInput
+				m.formatInput.Blur()
+				m.formatRulesInput.Focus()
+				return m, textinput.Blink
+			}
+
+		case stateFormatRulesInput:
+			if key.Matches(msg, m.keys.Enter) {
+				value := m.formatRulesInput.Value()
+				if value == "" {
+					m.err = nil
+					m.state = statePlacesInput
+					m.formatRulesInput.Blur()
+					m.placesInput.Focus()
+					return m, textinput.Blink
+				}
+
+				rule, err := file.ParseFormatRule(value)
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.err = nil
+				m.formatRules = append(m.formatRules, rule)
+				m.formatRulesInput.Reset()
 				return m, nil
 			}
 
+			// Take the last rule back into the input to edit it.
+			if msg.String() == "up" && m.formatRulesInput.Value() == "" && len(m.formatRules) > 0 {
+				rule := m.formatRules[len(m.formatRules)-1]
+				m.formatRules = m.formatRules[:len(m.formatRules)-1]
+				m.formatRulesInput.SetValue(rule.Pattern + "=" + rule.Template.String())
+				return m, nil
+			}
+
+		case statePlacesInput:
+			if key.Matches(msg, m.keys.Enter) {
+				m.places = nil
+				if path := m.placesInput.Value(); path != "" {
+					places, err := place.LoadPlaces(path)
+					if err != nil {
+						m.err = err
+						return m, nil
+					}
+					m.places = places
+				}
+				m.err = nil
+				m.state = statePlanning
+				return m, m.planFiles()
+			}
+
 		case stateConfirm:
+			// Patterns are typed into the selected input, only the arrow keys
+			// move to other options.
+			if input := m.confirmInput(); input != nil && msg.Type != tea.KeyUp && msg.Type != tea.KeyDown && !key.Matches(msg, m.keys.Enter) {
+				var cmd tea.Cmd
+				*input, cmd = input.Update(msg)
+				m.filterItems()
+				m.spaceWarned = false
+				return m, cmd
+			}
+
 			if key.Matches(msg, m.keys.Up) && m.confirmIndex > 0 {
 				m.confirmIndex--
+				return m, m.focusConfirmInput()
 			}
-			if key.Matches(msg, m.keys.Down) && m.confirmIndex < 1 {
+			if key.Matches(msg, m.keys.Down) && m.confirmIndex < confirmOptionCount-1 {
 				m.confirmIndex++
+				return m, m.focusConfirmInput()
 			}
 
 			if key.Matches(msg, m.keys.Space) {
-				if m.confirmIndex == 0 {
-					m.dryRun = !m.dryRun
-				} else if m.confirmIndex == 1 {
-					m.moveMode = !m.moveMode
-				}
+				m.toggleConfirmOption(m.confirmIndex)
+				m.spaceWarned = false
 				return m, nil
 			}
 
 			if key.Matches(msg, m.keys.Enter) {
+				if err := m.filterItems(); err != nil {
+					return m, nil
+				}
+				items, err := sorter.RenderDestinations(m.items, m.options())
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+
+				if err := sorter.CheckHardlinks(items, m.options()); err != nil {
+					m.err = err
+					return m, nil
+				}
+
+				// Warn before the first write when the destination lacks space,
+				// continuing when confirmed by pressing enter again. Duplicates
+				// are only found once processing starts, so they are counted.
+				if !m.dryRun && !m.spaceWarned {
+					space, err := sorter.NewRun(m.options()).CheckSpace(items)
+					if err == nil && !space.Sufficient() {
+						m.err = fmt.Errorf("not enough free space in the destination, %s. Press enter again to continue anyway", space)
+						m.spaceWarned = true
+						return m, nil
+					}
+				}
+				m.err = nil
+				m.items = items
+				m.run = sorter.NewRun(m.options())
+
 				m.state = stateProcessing
 				return m, m.processFiles()
 			}
@@ -339,6 +552,12 @@ func (
 	case stateFormatInput:
 		m.formatInput, cmd = m.formatInput.Update(msg)
 		cmds = append(cmds, cmd)
+	case stateFormatRulesInput:
+		m.formatRulesInput, cmd = m.formatRulesInput.Update(msg)
+		cmds = append(cmds, cmd)
+	case statePlacesInput:
+		m.placesInput, cmd = m.placesInput.Update(msg)
+		cmds = append(cmds, cmd)
 	}
 
 	return m, tea.Batch(cmds...)
@@ -376,48 +595,93 @@ func (
 		s.WriteString(m.formatInput.View())
 		s.WriteString("\n\nFormat placeholders:\n")
 		s.WriteString("%year%, %month%, %day%, %hour%, %minute%, %second%\n")
-		s.WriteString("%index%     - Incremental file count\n")
-		s.WriteString("%ext%       - File extension\n")
-		s.WriteString("%type%      - File type (flac,svg+xml,webm)\n")
-		s.WriteString("%mime-type% - File's mime-type (audio/flac,image/svg+xml,video/webm)\n")
+		for _, placeholder := range placeholders[6:] {
+			s.WriteString(fmt.Sprintf("%-14s - %s\n", placeholder[0], placeholder[1]))
+		}
+		s.WriteString("\nHash algorithms: md5, sha1, sha256 (default), sha512, blake3, xxh64\n")
+		s.WriteString("\nFilters, as in %camera-model|lower|slug%:\n")
+		filterNames := make([]string, len(filters))
+		for i, filter := range filters {
+			filterNames[i] = filter[0]
+		}
+		s.WriteString(strings.Join(filterNames, ", ") + "\n")
+		s.WriteString("Empty values can fall back to a default, as in %place|default:unknown-place%.\n")
+		s.WriteString("\nA literal percent sign is written as %%, empty path segments are dropped.\n")
+		s.WriteString(fmt.Sprintf("Start with %q to write a Go template instead, see `file_sorter --help`.\n", file.TEMPLATE_PREFIX))
 		s.WriteString(fmt.Sprintf("\nDefault format:\n%s", FORMAT_PLACEHOLDER))
+		if m.err != nil {
+			style := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+			s.WriteString("\n\n" + style.Render(m.err.Error()))
+		}
 		break
 
-	case stateConfirm:
-		format := m.formatInput.Value()
-		if format == "" {
-			format = m.formatInput.Placeholder
+	case stateFormatRulesInput:
+		s.WriteString("Enter formats per type as pattern=format (optional):\n")
+		for _, rule := range m.formatRules {
+			s.WriteString(fmt.Sprintf("  %s=%s\n", rule.Pattern, rule.Template))
 		}
-		s.WriteString(fmt.Sprintf("Source:      %s\n", m.sourcePicker.CurrentDirectory))
-		s.WriteString(fmt.Sprintf("Destination: %s\n", m.destPicker.CurrentDirectory))
-		s.WriteString(fmt.Sprintf("Format:      %s\n", format))
+		s.WriteString(m.formatRulesInput.View())
+		s.WriteString("\n\nLeave empty and confirm to continue, ↑ to edit the last entry.\n")
+		s.WriteString("The most specific pattern wins, other files use the default format.\n")
+		s.WriteString("\nFormat patterns:\n")
+		s.WriteString("Extension:  .pdf, .jpg\n")
+		s.WriteString("Mime-type:  application/pdf, image/*\n")
+		s.WriteString(fmt.Sprintf("Category:   %s\n", strings.Join(file.GetCategories(), ", ")))
+		if m.err != nil {
+			style := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+			s.WriteString("\n" + style.Render(m.err.Error()))
+		}
+		break
 
-		dryRunCheckbox := " Log without changing files"
-		if m.dryRun {
-			dryRunCheckbox = "[X]" + dryRunCheckbox
-		} else {
-			dryRunCheckbox = "[ ]" + dryRunCheckbox
+	case statePlacesInput:
+		s.WriteString("Enter places file (optional):\n")
+		s.WriteString(m.placesInput.View())
+		s.WriteString("\n\nA GeoJSON feature collection where every feature has a \"name\" property.\n")
+		s.WriteString("Points need a \"radius\" property in meters, polygons are used as is.\n")
+		s.WriteString("Overlapping places are resolved by the highest \"priority\" property.\n")
+		if m.err != nil {
+			style := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+			s.WriteString("\n" + style.Render(m.err.Error()))
 		}
-		moveModeCheckbox := " Move instead of copy"
-		if m.moveMode {
-			moveModeCheckbox = "[X]" + moveModeCheckbox
-		} else {
-			moveModeCheckbox = "[ ]" + moveModeCheckbox
+		break
+
+	case statePlanning:
+		s.WriteString("Scanning source directory...\n")
+		if m.err != nil {
+			style := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+			s.WriteString("\n" + style.Render(m.err.Error()))
 		}
+		break
 
-		if m.confirmIndex == 0 {
-			dryRunCheckbox = "> " + dryRunCheckbox
-		} else {
-			dryRunCheckbox = "  " + dryRunCheckbox
+	case stateConfirm:
+		s.WriteString(fmt.Sprintf("Source:      %s\n", m.sourcePicker.CurrentDirectory))
+		s.WriteString(fmt.Sprintf("Destination: %s\n", m.destPicker.CurrentDirectory))
+		s.WriteString(fmt.Sprintf("Format:      %s\n", m.format))
+		for _, rule := range m.formatRules {
+			s.WriteString(fmt.Sprintf("  %s: %s\n", rule.Pattern, rule.Template))
 		}
-		if m.confirmIndex == 1 {
-			moveModeCheckbox = "> " + moveModeCheckbox
-		} else {
-			moveModeCheckbox = "  " + moveModeCheckbox
+		s.WriteString(fmt.Sprintf("Files:       %d\n", len(m.items)))
+
+		if len(m.places) > 0 {
+			counts := sorter.CountPlaces(m.items)
+			s.WriteString("\nFiles per place:\n")
+			for _, namedPlace := range m.places {
+				if count, ok := counts[namedPlace.Name]; ok {
+					s.WriteString(fmt.Sprintf("  %s: %d\n", namedPlace.Name, count))
+					delete(counts, namedPlace.Name)
+				}
+			}
+			s.WriteString(fmt.Sprintf("  No place: %d\n", counts[""]))
 		}
 
-		s.WriteString("\n" + dryRunCheckbox)
-		s.WriteString("\n" + moveModeCheckbox)
+		s.WriteString("\n")
+		for index := 0; index < confirmOptionCount; index++ {
+			s.WriteString("\n" + m.viewConfirmOption(index))
+		}
+		if m.err != nil {
+			style := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+			s.WriteString("\n\n" + style.Render(m.err.Error()))
+		}
 		break
 
 	case stateProcessing:
@@ -448,6 +712,33 @@ func (
 			}
 		}
 		s.WriteString(fmt.Sprintf("\nProcessed %d files in total.", m.total))
+		if summary := m.run.Summary; summary.Collisions > 0 {
+			s.WriteString(fmt.Sprintf("\nCollisions: %d (%d renamed, %d overwritten, %d skipped).",
+				summary.Collisions, summary.Renamed, summary.Overwritten, summary.Skipped))
+		}
+		if summary := m.run.Summary; summary.MovedByCopy > 0 {
+			s.WriteString(fmt.Sprintf("\nMoved %d files by rename and %d by copying them to another file system.",
+				summary.MovedByRename, summary.MovedByCopy))
+		}
+		if summary := m.run.Summary; summary.Failed > 0 {
+			s.WriteString(fmt.Sprintf("\nFailed: %d files whose copies could not be verified were left in place.", summary.Failed))
+		}
+		if m.duplicateGroups > 0 {
+			s.WriteString(fmt.Sprintf("\nFound %d groups of duplicates, %d files handled as duplicates.", m.duplicateGroups, m.run.Summary.Duplicates))
+		}
+		if summary := m.run.Summary; summary.Linked > 0 {
+			s.WriteString(fmt.Sprintf("\nLinked %d duplicates to their original, their sources were left in place.", summary.Linked))
+		}
+		if m.pruned > 0 {
+			s.WriteString(fmt.Sprintf("\nRemoved %d emptied source directories.", m.pruned))
+		}
+		if m.journalPath != "" {
+			s.WriteString(fmt.Sprintf("\nJournal written to %s, undo with `file_sorter undo <journal>`.", m.journalPath))
+		}
+		if m.err != nil {
+			style := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+			s.WriteString("\n" + style.Render(m.err.Error()))
+		}
 		break
 	}
 
@@ -471,118 +762,239 @@ func (
 
 func (
 	m *model,
-) processFiles() tea.Cmd {
-	return func() tea.Msg {
-		var totalFiles int
-		filepath.Walk(m.sourcePicker.CurrentDirectory, func(path string, info os.FileInfo, err error) error {
-			if err != nil || info.IsDir() || strings.HasPrefix(path, m.destPicker.CurrentDirectory) {
-				return nil
-			}
-
-			rel, err := filepath.Rel(m.destPicker.CurrentDirectory, path)
-			if err == nil && !strings.HasPrefix(rel, "..") {
-				return nil
-			}
+) toggleConfirmOption(
+	index int,
+) {
+	switch index {
+	case confirmDryRun:
+		m.dryRun = !m.dryRun
+	case confirmTransfer:
+		m.transferMode = (m.transferMode + 1) % len(sorter.GetTransferModes())
+	case confirmPreserve:
+		m.preserve = !m.preserve
+	case confirmPruneEmpty:
+		m.pruneEmpty = !m.pruneEmpty
+	case confirmSanitize:
+		m.sanitizeProfile = (m.sanitizeProfile + 1) % len(file.GetSanitizeProfiles())
+	case confirmNormalize:
+		m.normalization = (m.normalization + 1) % len(file.GetNormalizations())
+	case confirmCollision:
+		m.collision = (m.collision + 1) % len(sorter.GetCollisionPolicies())
+	case confirmDuplicates:
+		m.duplicates = (m.duplicates + 1) % len(sorter.GetDuplicatePolicies())
+	}
+}
 
-			totalFiles++
-			return nil
-		})
+// Returns the input of the selected confirm option, if it is edited by typing.
+func (
+	m *model,
+) confirmInput() *textinput.Model {
+	if m.state != stateConfirm {
+		return nil
+	}
+	switch m.confirmIndex {
+	case confirmInclude:
+		return &m.includeInput
+	case confirmExclude:
+		return &m.excludeInput
+	}
+	return nil
+}
 
-		return processingStarted{totalFiles: totalFiles}
+// Focuses the input of the selected confirm option and blurs the others.
+func (
+	m *model,
+) focusConfirmInput() tea.Cmd {
+	m.includeInput.Blur()
+	m.excludeInput.Blur()
+	if input := m.confirmInput(); input != nil {
+		return input.Focus()
 	}
+	return nil
 }
 
+// Applies the include and exclude patterns to the planned items, reporting
+// invalid patterns.
 func (
 	m *model,
-) processNextFile(
+) filterItems() error {
+	for _, input := range []textinput.Model{m.includeInput, m.excludeInput} {
+		if _, err := sorter.ParsePathPatterns(input.Value()); err != nil {
+			m.err = err
+			return err
+		}
+	}
+	m.err = nil
+	m.items = sorter.FilterItems(m.planned, m.options())
+	return nil
+}
+
+func (
+	m model,
+) viewConfirmOption(
 	index int,
-) tea.Cmd {
-	return func() tea.Msg {
-		var currentFile string
-		var currentIndex int
-
-		// Find the nth unprocessed file
-		err := filepath.Walk(
-			m.sourcePicker.CurrentDirectory,
-			func(
-				path string,
-				info os.FileInfo,
-				err error,
-			) error {
-				if err != nil {
-					return err
-				}
+) string {
+	var option string
+	switch index {
+	case confirmDryRun:
+		option = viewCheckbox("Log without changing files", m.dryRun)
+	case confirmTransfer:
+		option = viewChoice("Transfer mode", sorter.GetTransferModes()[m.transferMode])
+	case confirmPreserve:
+		option = viewCheckbox("Keep times, permissions, owner and attributes on copies", m.preserve)
+	case confirmPruneEmpty:
+		option = viewCheckbox("Remove source directories emptied by moving", m.pruneEmpty)
+	case confirmSanitize:
+		option = viewChoice("File names valid for", file.GetSanitizeProfiles()[m.sanitizeProfile])
+	case confirmNormalize:
+		option = viewChoice("Unicode normalization", file.GetNormalizations()[m.normalization])
+	case confirmCollision:
+		option = viewChoice("When the destination exists", sorter.GetCollisionPolicies()[m.collision])
+	case confirmDuplicates:
+		option = viewChoice("Duplicates", sorter.GetDuplicatePolicies()[m.duplicates])
+	case confirmInclude:
+		option = "Include: " + m.includeInput.View()
+	case confirmExclude:
+		option = "Exclude: " + m.excludeInput.View()
+	}
 
-				if info.IsDir() {
-					return nil
-				}
+	if m.confirmIndex == index {
+		return "> " + option
+	}
+	return "  " + option
+}
 
-				if strings.HasPrefix(path, m.destPicker.CurrentDirectory) {
-					return nil
-				}
+func viewCheckbox(
+	label string,
+	checked bool,
+) string {
+	if checked {
+		return "[X] " + label
+	}
+	return "[ ] " + label
+}
 
-				if currentIndex == index {
-					currentFile = path
-					return fmt.Errorf("found") // Use error to break the walk
-				}
+func viewChoice(
+	label string,
+	value string,
+) string {
+	return fmt.Sprintf("<%s> %s", value, label)
+}
 
-				currentIndex++
-				return nil
-			},
-		)
+// Returns the verb describing the selected transfer mode.
+func (
+	m model,
+) transferVerb() string {
+	return transferVerbs[sorter.GetTransferModes()[m.transferMode]][0]
+}
 
-		if err != nil && err.Error() != "found" {
-			return fileProcessed{error: err}
-		}
+func (
+	m *model,
+) options() sorter.Options {
+	sanitizer, _ := file.NewSanitizer(
+		file.GetSanitizeProfiles()[m.sanitizeProfile],
+		"_",
+		file.GetNormalizations()[m.normalization],
+	)
 
-		if currentFile == "" {
-			return processingFinished{}
-		}
+	var preservation file.Preservation
+	if m.preserve {
+		preservation = file.PreserveAll()
+	}
 
-		// Process the file
-		creationDate, err := file.GetFileCreationDate(currentFile)
-		if err != nil {
-			return fileProcessed{
-				path:  currentFile,
-				error: fmt.Errorf("error getting creation date: %w", err),
-			}
-		}
+	// Invalid patterns are reported while typing them.
+	include, _ := sorter.ParsePathPatterns(m.includeInput.Value())
+	exclude, _ := sorter.ParsePathPatterns(m.excludeInput.Value())
+
+	return sorter.Options{
+		SourceDir: m.sourcePicker.CurrentDirectory,
+		DestDir:   m.destPicker.CurrentDirectory,
+		Format:    m.format,
+		Transfer:  sorter.GetTransferModes()[m.transferMode],
+		DryRun:    m.dryRun,
+
+		FormatRules: m.formatRules,
+		Sanitizer:   sanitizer,
+		Places:      m.places,
+		Collision:   sorter.GetCollisionPolicies()[m.collision],
+		Preserve:    preservation,
+		Duplicates:  sorter.GetDuplicatePolicies()[m.duplicates],
+		Include:     include,
+		Exclude:     exclude,
+	}
+}
 
-		format := m.formatInput.Value()
-		if format == "" {
-			format = m.formatInput.Placeholder
+func (
+	m *model,
+) planFiles() tea.Cmd {
+	options := m.options()
+	return func() tea.Msg {
+		items, _, err := sorter.Plan(options)
+		return filesPlanned{
+			items: items,
+			error: err,
 		}
+	}
+}
 
-		newFileName := file.FormatName(format, creationDate, index+1, currentFile)
-		destinationPath := filepath.Join(m.destPicker.CurrentDirectory, newFileName)
+func (
+	m *model,
+) processFiles() tea.Cmd {
+	return func() tea.Msg {
+		duplicateGroups := 0
+		if sorter.GetDuplicatePolicies()[m.duplicates] != sorter.DUPLICATES_KEEP {
+			groups, err := sorter.FindDuplicates(m.items, m.options())
+			if err != nil {
+				return fileProcessed{error: err}
+			}
+			m.run.SetDuplicates(groups)
+			duplicateGroups = len(groups)
+		}
 
+		journalPath := ""
 		if !m.dryRun {
-			if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
-				return fileProcessed{
-					path:  currentFile,
-					error: fmt.Errorf("failed to create directory: %w", err),
-				}
-			}
+			// Temporary files that can not be removed are left for a later run.
+			m.run.RemoveTemporaryFiles(m.items)
 
-			if m.moveMode {
-				err = os.Rename(currentFile, destinationPath)
-			} else {
-				err = file.CopyFile(currentFile, destinationPath)
+			var err error
+			journalPath, err = m.run.Start(m.items)
+			if err != nil {
+				return fileProcessed{error: err}
 			}
+		}
+		return processingStarted{
+			totalFiles:      len(m.items),
+			journalPath:     journalPath,
+			duplicateGroups: duplicateGroups,
+		}
+	}
+}
 
-			if err != nil {
-				return fileProcessed{
-					path: currentFile,
-					error: fmt.Errorf("failed to %s file: %w",
-						map[bool]string{true: "move", false: "copy"}[m.moveMode],
-						err),
-				}
+func (
+	m *model,
+) processNextFile(
+	index int,
+) tea.Cmd {
+	return func() tea.Msg {
+		if index >= len(m.items) {
+			return processingFinished{}
+		}
+		result, err := m.run.Process(m.items[index])
+		item := result.Item
+		if err != nil {
+			return fileProcessed{
+				path:  item.Path,
+				error: fmt.Errorf("failed to %s file: %w", m.transferVerb(), err),
 			}
 		}
 
 		return fileProcessed{
-			path:            currentFile,
-			destinationPath: destinationPath,
+			path:            item.Path,
+			destinationPath: item.DestinationPath,
+			outcome:         result.Outcome,
+			crossDevice:     result.CrossDevice,
+			linked:          result.Link != "",
+			copied:          result.Copied,
 		}
 	}
 }
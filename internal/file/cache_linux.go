@@ -0,0 +1,17 @@
+//go:build linux
+
+package file
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Evicts the cached pages of the file so it is read from disk. The file has
+// to be synced already, dirty pages are not evicted.
+func dropCache(
+	file *os.File,
+) {
+	unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
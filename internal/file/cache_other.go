@@ -0,0 +1,14 @@
+//go:build !linux
+
+package file
+
+import (
+	"os"
+)
+
+// The page cache can only be bypassed on Linux, elsewhere the file is read as
+// usual.
+func dropCache(
+	file *os.File,
+) {
+}
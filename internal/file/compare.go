@@ -0,0 +1,37 @@
+package file
+
+import (
+	"os"
+)
+
+// Whether two files have the same contents, comparing their sizes before their
+// hashes.
+func IdenticalFiles(
+	pathA string,
+	pathB string,
+) (
+	bool,
+	error,
+) {
+	infoA, err := os.Stat(pathA)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(pathB)
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	hashesA, err := HashFile(pathA, []string{HASH_DEFAULT})
+	if err != nil {
+		return false, err
+	}
+	hashesB, err := HashFile(pathB, []string{HASH_DEFAULT})
+	if err != nil {
+		return false, err
+	}
+	return hashesA[HASH_DEFAULT] == hashesB[HASH_DEFAULT], nil
+}
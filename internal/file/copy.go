@@ -0,0 +1,135 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// How the contents of a file are copied.
+const (
+	// Picks the fastest mechanism available, falling back to the next.
+	COPY_MODE_AUTO = "auto"
+	// Clones the file on copy-on-write file systems such as btrfs and xfs.
+	COPY_MODE_REFLINK = "reflink"
+	// Lets the kernel copy the data without passing through user space.
+	COPY_MODE_KERNEL = "kernel"
+	// Reads and writes the data through a buffer.
+	COPY_MODE_USERSPACE = "userspace"
+)
+
+var copyModes = []string{
+	COPY_MODE_AUTO,
+	COPY_MODE_REFLINK,
+	COPY_MODE_KERNEL,
+	COPY_MODE_USERSPACE,
+}
+
+// Returned by a copy mechanism that is not available for the files.
+var errCopyUnsupported = errors.New("not supported for these files")
+
+// Returns the available copy modes, the first is the default.
+func GetCopyModes() []string {
+	return copyModes
+}
+
+// Checks whether the value is a known copy mode.
+func ParseCopyMode(
+	value string,
+) (
+	string,
+	error,
+) {
+	if !slices.Contains(copyModes, value) {
+		return "", fmt.Errorf("unknown copy mode %q, expected one of %s", value, strings.Join(copyModes, ", "))
+	}
+	return value, nil
+}
+
+// How files are copied.
+type CopyOptions struct {
+	Preserve Preservation
+	Mode     string
+}
+
+// Copies the contents using the mode, hashing them with the given algorithms.
+// In auto mode a clone is tried first, then the kernel copy and finally a copy
+// in user space. Contents that are cloned or copied in the kernel are hashed by
+// reading the source again, so the digests always describe the source.
+func copyContents(
+	destination *os.File,
+	source *os.File,
+	mode string,
+	algorithms []string,
+) (
+	map[string]string,
+	error,
+) {
+	var err error
+	switch mode {
+	case COPY_MODE_REFLINK:
+		err = reflinkCopy(destination, source)
+	case COPY_MODE_KERNEL:
+		err = kernelCopy(destination, source)
+		// Files without a size are left to the copy in user space.
+		if info, statErr := source.Stat(); errors.Is(err, errCopyUnsupported) && statErr == nil && info.Size() == 0 {
+			return userspaceCopy(destination, source, algorithms)
+		}
+	case COPY_MODE_USERSPACE:
+		return userspaceCopy(destination, source, algorithms)
+	default:
+		err = reflinkCopy(destination, source)
+		if errors.Is(err, errCopyUnsupported) {
+			err = kernelCopy(destination, source)
+		}
+		if errors.Is(err, errCopyUnsupported) {
+			return userspaceCopy(destination, source, algorithms)
+		}
+	}
+	if errors.Is(err, errCopyUnsupported) {
+		return nil, fmt.Errorf("copy mode %s is %w", mode, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy file contents: %w", err)
+	}
+
+	if len(algorithms) == 0 {
+		return nil, nil
+	}
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to hash file contents: %w", err)
+	}
+	hashWriter, digests := newHashWriter(algorithms)
+	if _, err := io.Copy(hashWriter, source); err != nil {
+		return nil, fmt.Errorf("failed to hash file contents: %w", err)
+	}
+	return digests(), nil
+}
+
+func userspaceCopy(
+	destination io.Writer,
+	source io.Reader,
+	algorithms []string,
+) (
+	map[string]string,
+	error,
+) {
+	// Hides the ReadFrom method of the file, which would use the kernel copy.
+	writer := struct{ io.Writer }{destination}
+
+	if len(algorithms) == 0 {
+		if _, err := io.Copy(writer, source); err != nil {
+			return nil, fmt.Errorf("failed to copy file contents: %w", err)
+		}
+		return nil, nil
+	}
+
+	hashWriter, digests := newHashWriter(algorithms)
+	if _, err := io.Copy(io.MultiWriter(writer, hashWriter), source); err != nil {
+		return nil, fmt.Errorf("failed to copy file contents: %w", err)
+	}
+	return digests(), nil
+}
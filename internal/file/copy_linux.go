@@ -0,0 +1,96 @@
+//go:build linux
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// The largest amount of data copied by a single system call.
+const KERNEL_COPY_CHUNK = 1 << 30
+
+// Whether the error means the mechanism is not available for these files,
+// rather than that copying failed.
+func isCopyUnsupported(
+	err error,
+) bool {
+	return errors.Is(err, unix.EOPNOTSUPP) ||
+		errors.Is(err, unix.ENOTSUP) ||
+		errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.ENOTTY)
+}
+
+// Clones the source with the FICLONE ioctl, sharing its data blocks.
+func reflinkCopy(
+	destination *os.File,
+	source *os.File,
+) error {
+	err := unix.IoctlFileClone(int(destination.Fd()), int(source.Fd()))
+	if err != nil && isCopyUnsupported(err) {
+		return errCopyUnsupported
+	}
+	return err
+}
+
+// Copies with copy_file_range, falling back to sendfile on kernels and file
+// systems without support. Files reporting a size of zero and copies that end
+// before any data is copied are reported as unsupported, as some file systems
+// do not report their size or data to the kernel, so they are read in user
+// space instead. The copied size is checked against the size of the source.
+func kernelCopy(
+	destination *os.File,
+	source *os.File,
+) error {
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errCopyUnsupported
+	}
+
+	destinationFd := int(destination.Fd())
+	sourceFd := int(source.Fd())
+
+	copied := 0
+	useSendfile := false
+	for {
+		var count int
+		var err error
+		if useSendfile {
+			count, err = unix.Sendfile(destinationFd, sourceFd, nil, KERNEL_COPY_CHUNK)
+		} else {
+			count, err = unix.CopyFileRange(sourceFd, nil, destinationFd, nil, KERNEL_COPY_CHUNK, 0)
+		}
+
+		if err != nil && copied == 0 && isCopyUnsupported(err) {
+			if useSendfile {
+				return errCopyUnsupported
+			}
+			useSendfile = true
+			continue
+		}
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			if copied == 0 {
+				return errCopyUnsupported
+			}
+			if int64(copied) != info.Size() {
+				return fmt.Errorf("copied %d of %d bytes", copied, info.Size())
+			}
+			return nil
+		}
+		copied += count
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package file
+
+import (
+	"os"
+)
+
+// Cloning is only implemented on Linux.
+func reflinkCopy(
+	destination *os.File,
+	source *os.File,
+) error {
+	return errCopyUnsupported
+}
+
+// Copying in the kernel is only implemented on Linux.
+func kernelCopy(
+	destination *os.File,
+	source *os.File,
+) error {
+	return errCopyUnsupported
+}
@@ -5,28 +5,19 @@ import (
 	"os"
 	"time"
 
-	exif "github.com/dsoprea/go-exif-knife"
+	exif "github.com/dsoprea/go-exif/v3"
 )
 
 // Attempts to extract the creation date of a file.
 func GetFileCreationDate(
 	filePath string,
+	exifData Exif,
 ) (
 	time.Time,
 	error,
 ) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return time.Time{}, err
-	}
-	defer file.Close()
-
-	mediaContext, err := exif.GetExif(filePath)
-	if err == nil {
-		time, err := getFileCreationDateFromExif(mediaContext)
-		if err == nil {
-			return time, nil
-		}
+	if !exifData.DateTime.IsZero() {
+		return exifData.DateTime, nil
 	}
 
 	// Fallback to file info modification time.
@@ -39,16 +30,16 @@ func GetFileCreationDate(
 }
 
 func getFileCreationDateFromExif(
-	mediaContext *exif.MediaContext,
+	rootIfd *exif.Ifd,
 ) (
 	time.Time,
 	error,
 ) {
-	if mediaContext == nil || mediaContext.RootIfd == nil {
+	if rootIfd == nil {
 		return time.Time{}, fmt.Errorf("no root IFD found")
 	}
 
-	dateTimeTags, err := mediaContext.RootIfd.FindTagWithName("DateTime")
+	dateTimeTags, err := rootIfd.FindTagWithName("DateTime")
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -0,0 +1,121 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// The amount of data read from the start of a file when searching for EXIF
+// data, which sits near the start of the formats that have it.
+const EXIF_SEARCH_LIMIT = 8 << 20
+
+// Image types that can not hold EXIF data.
+var exifLessTypes = []string{
+	"image/svg+xml",
+	"image/gif",
+	"image/bmp",
+}
+
+// Values read from the EXIF data of a file, left empty when missing.
+type Exif struct {
+	DateTime    time.Time
+	CameraMake  string
+	CameraModel string
+
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+}
+
+// Attempts to read the EXIF data of a file in a single pass. Only images are
+// searched, and only up to the search limit, so large files are not read.
+func GetFileExif(
+	filePath string,
+) (
+	Exif,
+	error,
+) {
+	var exifData Exif
+	if !hasExif(filePath) {
+		return exifData, nil
+	}
+
+	imageFile, err := os.Open(filePath)
+	if err != nil {
+		return exifData, err
+	}
+	defer imageFile.Close()
+
+	rawExif, err := exif.SearchAndExtractExifWithReader(io.LimitReader(imageFile, EXIF_SEARCH_LIMIT))
+	if errors.Is(err, exif.ErrNoExif) {
+		return exifData, nil
+	}
+	if err != nil {
+		return exifData, err
+	}
+
+	ifdMapping, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		return exifData, err
+	}
+	_, index, err := exif.Collect(ifdMapping, exif.NewTagIndex(), rawExif)
+	if err != nil {
+		return exifData, err
+	}
+	rootIfd := index.RootIfd
+	if rootIfd == nil {
+		return exifData, nil
+	}
+
+	exifData.DateTime, _ = getFileCreationDateFromExif(rootIfd)
+	exifData.CameraMake = getExifString(rootIfd, "Make")
+	exifData.CameraModel = getExifString(rootIfd, "Model")
+
+	gpsIfd, err := rootIfd.ChildWithIfdPath(exifcommon.IfdGpsInfoStandardIfdIdentity)
+	if err == nil {
+		gpsInfo, err := gpsIfd.GpsInfo()
+		if err == nil {
+			exifData.HasLocation = true
+			exifData.Latitude = gpsInfo.Latitude.Decimal()
+			exifData.Longitude = gpsInfo.Longitude.Decimal()
+		}
+	}
+
+	return exifData, nil
+}
+
+// Whether the file is an image type that can hold EXIF data.
+func hasExif(
+	filePath string,
+) bool {
+	mimeType := getMimeType(filePath)
+	return strings.HasPrefix(mimeType, "image/") && !slices.Contains(exifLessTypes, mimeType)
+}
+
+func getExifString(
+	rootIfd *exif.Ifd,
+	name string,
+) string {
+	tags, err := rootIfd.FindTagWithName(name)
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+
+	value, err := tags[0].Value()
+	if err != nil {
+		return ""
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(strings.Trim(text, "\x00"))
+}
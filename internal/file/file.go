@@ -1,30 +1,160 @@
 package file
 
 import (
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 )
 
+// Name pattern of the temporary files written to the destination directory.
+const TEMPORARY_PATTERN = ".file_sorter-*.tmp"
+
+// Permissions of copied files, temporary files are created readable by the
+// owner only.
+const FILE_MODE = 0o644
+
+// Copies the file contents, hashing them with the given algorithms. The
+// contents are written to a temporary file first, so the destination is either
+// complete or absent when the process is interrupted.
 func CopyFile(
 	path string,
 	destinationPath string,
-) error {
+	options CopyOptions,
+	algorithms ...string,
+) (
+	map[string]string,
+	error,
+) {
+	temporaryPath, digests, err := CopyToTemporary(path, filepath.Dir(destinationPath), options, algorithms...)
+	if err != nil {
+		return nil, err
+	}
+	if err := CommitFile(temporaryPath, destinationPath); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// Copies the file contents and the preserved metadata to a new temporary file
+// in the directory and syncs it to disk. The temporary file is removed again
+// when copying fails.
+func CopyToTemporary(
+	path string,
+	directory string,
+	options CopyOptions,
+	algorithms ...string,
+) (
+	string,
+	map[string]string,
+	error,
+) {
 	sourceFile, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to open source file: %w", err)
+		return "", nil, fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer sourceFile.Close()
 
-	destinationFile, err := os.Create(destinationPath)
+	temporaryFile, err := os.CreateTemp(directory, TEMPORARY_PATTERN)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
+		return "", nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	temporaryPath := temporaryFile.Name()
+
+	digests, err := copyContents(temporaryFile, sourceFile, options.Mode, algorithms)
+	if err == nil {
+		err = temporaryFile.Chmod(FILE_MODE)
+		if err != nil {
+			err = fmt.Errorf("failed to set permissions: %w", err)
+		}
+	}
+	if err == nil {
+		err = temporaryFile.Sync()
+		if err != nil {
+			err = fmt.Errorf("failed to sync file contents: %w", err)
+		}
+	}
+	if closeErr := temporaryFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close temporary file: %w", closeErr)
+	}
+	if err == nil {
+		err = preserveMetadata(path, temporaryPath, options.Preserve)
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		return "", nil, err
+	}
+	return temporaryPath, digests, nil
+}
+
+// Renames a temporary file into place and syncs the directory so the rename
+// survives a crash. The temporary file is removed when renaming fails.
+func CommitFile(
+	temporaryPath string,
+	destinationPath string,
+) error {
+	if err := os.Rename(temporaryPath, destinationPath); err != nil {
+		os.Remove(temporaryPath)
+		return fmt.Errorf("failed to rename temporary file: %w", err)
 	}
-	defer destinationFile.Close()
+	return SyncDirectory(filepath.Dir(destinationPath))
+}
 
-	_, err = io.Copy(destinationFile, sourceFile)
+// Flushes the directory entries to disk. Windows does not support syncing
+// directories, renames are durable there already.
+func SyncDirectory(
+	directory string,
+) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	directoryFile, err := os.Open(directory)
 	if err != nil {
-		return fmt.Errorf("failed to copy file contents: %w", err)
+		return fmt.Errorf("failed to open directory %s: %w", directory, err)
+	}
+	defer directoryFile.Close()
+
+	if err := directoryFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", directory, err)
 	}
 	return nil
 }
+
+// Removes temporary files left behind by interrupted runs from the directory,
+// without descending into its subdirectories, and returns how many were
+// removed. A directory that does not exist has none.
+func RemoveTemporaryFiles(
+	directory string,
+) (
+	int,
+	error,
+) {
+	entries, err := os.ReadDir(directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory %s: %w", directory, err)
+	}
+
+	prefix, suffix, _ := strings.Cut(TEMPORARY_PATTERN, "*")
+
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+
+		path := filepath.Join(directory, name)
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("failed to remove temporary file %s: %w", path, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
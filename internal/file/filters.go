@@ -0,0 +1,123 @@
+package file
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A filter applied to a placeholder value, written as %placeholder|filter:argument%.
+type templateFilter struct {
+	name      string
+	arguments []string
+}
+
+// The known filters and the number of arguments they take.
+var filterArguments = map[string]int{
+	"lower":    0,
+	"upper":    0,
+	"trim":     0,
+	"slug":     0,
+	"truncate": 1,
+	"pad":      1,
+	"default":  1,
+	"replace":  2,
+}
+
+func parseFilter(
+	token string,
+) (
+	templateFilter,
+	error,
+) {
+	fields := strings.Split(token, ":")
+	filter := templateFilter{
+		name:      fields[0],
+		arguments: fields[1:],
+	}
+
+	count, ok := filterArguments[filter.name]
+	if !ok {
+		return templateFilter{}, fmt.Errorf("unknown filter %q", filter.name)
+	}
+	if len(filter.arguments) != count {
+		return templateFilter{}, fmt.Errorf("filter %q takes %d argument(s)", filter.name, count)
+	}
+	for _, argument := range filter.arguments {
+		if strings.ContainsAny(argument, "/\\") {
+			return templateFilter{}, fmt.Errorf("argument %q of filter %q should not contain path separators", argument, filter.name)
+		}
+	}
+
+	switch filter.name {
+	case "truncate", "pad":
+		length, err := strconv.Atoi(filter.arguments[0])
+		if err != nil || length <= 0 {
+			return templateFilter{}, fmt.Errorf("invalid length %q for filter %q", filter.arguments[0], filter.name)
+		}
+	case "replace":
+		if filter.arguments[0] == "" {
+			return templateFilter{}, fmt.Errorf("filter \"replace\" needs a value to replace")
+		}
+	}
+	return filter, nil
+}
+
+func (
+	filter templateFilter,
+) apply(
+	value string,
+) string {
+	switch filter.name {
+	case "lower":
+		return strings.ToLower(value)
+	case "upper":
+		return strings.ToUpper(value)
+	case "trim":
+		return strings.TrimSpace(value)
+	case "slug":
+		return slugify(value)
+	case "truncate":
+		length, _ := strconv.Atoi(filter.arguments[0])
+		if utf8.RuneCountInString(value) > length {
+			return string([]rune(value)[:length])
+		}
+		return value
+	case "pad":
+		length, _ := strconv.Atoi(filter.arguments[0])
+		if missing := length - utf8.RuneCountInString(value); missing > 0 {
+			return strings.Repeat("0", missing) + value
+		}
+		return value
+	case "default":
+		if value == "" {
+			return filter.arguments[0]
+		}
+		return value
+	case "replace":
+		return strings.ReplaceAll(value, filter.arguments[0], filter.arguments[1])
+	}
+	return value
+}
+
+// Replaces every run of characters other than letters and digits by a dash.
+func slugify(
+	value string,
+) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range value {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return slug.String()
+}
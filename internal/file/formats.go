@@ -0,0 +1,195 @@
+package file
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A format used for the files matching a pattern. The pattern is either a
+// category (image), a mime-type glob (image/*, application/pdf) or an
+// extension (.pdf).
+type FormatRule struct {
+	Pattern  string
+	Template *Template
+}
+
+var categories = []string{
+	"image",
+	"video",
+	"audio",
+	"document",
+	"code",
+	"config",
+	"archive",
+	"other",
+}
+
+// Parses a rule written as pattern=format.
+func ParseFormatRule(
+	value string,
+) (
+	FormatRule,
+	error,
+) {
+	pattern, format, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(pattern) == "" || format == "" {
+		return FormatRule{}, fmt.Errorf("format rule %q should be written as pattern=format", value)
+	}
+	pattern, err := ParseTypePattern(pattern)
+	if err != nil {
+		return FormatRule{}, err
+	}
+
+	template, err := CompileFormat(format)
+	if err != nil {
+		return FormatRule{}, err
+	}
+
+	return FormatRule{
+		Pattern:  pattern,
+		Template: template,
+	}, nil
+}
+
+// Parses a pattern of file types, either a category (image), a mime-type glob
+// (image/*, application/pdf) or an extension (.pdf).
+func ParseTypePattern(
+	value string,
+) (
+	string,
+	error,
+) {
+	pattern := strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case strings.HasPrefix(pattern, "."):
+	case strings.Contains(pattern, "/"):
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", fmt.Errorf("invalid mime-type pattern %q: %w", pattern, err)
+		}
+	default:
+		known := false
+		for _, category := range categories {
+			if pattern == category {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", fmt.Errorf("unknown category %q, expected one of %s", pattern, strings.Join(categories, ", "))
+		}
+	}
+	return pattern, nil
+}
+
+// Reports whether the file is of a type matching the pattern.
+func MatchType(
+	pattern string,
+	filePath string,
+) bool {
+	mimeType := getMimeType(filePath)
+	return typeRank(pattern, strings.ToLower(filepath.Ext(filePath)), mimeType, getCategory(mimeType)) > 0
+}
+
+// Returns the template of the most specific rule matching the file, falling back
+// to the default format. Extensions are more specific than exact mime-types,
+// which are more specific than mime-type globs and categories.
+func SelectFormat(
+	rules []FormatRule,
+	defaultTemplate *Template,
+	filePath string,
+) *Template {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	mimeType := getMimeType(filePath)
+	category := getCategory(mimeType)
+
+	bestTemplate := defaultTemplate
+	bestRank := 0
+	for _, rule := range rules {
+		if rank := typeRank(rule.Pattern, ext, mimeType, category); rank > bestRank {
+			bestTemplate = rule.Template
+			bestRank = rank
+		}
+	}
+	return bestTemplate
+}
+
+// Returns how specifically the pattern matches the file type, zero when it
+// does not match.
+func typeRank(
+	pattern string,
+	ext string,
+	mimeType string,
+	category string,
+) int {
+	switch {
+	case strings.HasPrefix(pattern, "."):
+		if pattern == ext {
+			return 4
+		}
+	case pattern == mimeType:
+		return 3
+	case strings.Contains(pattern, "/"):
+		if matched, _ := path.Match(pattern, mimeType); matched {
+			return 2
+		}
+	case pattern == category:
+		return 1
+	}
+	return 0
+}
+
+// Returns the categories usable in format rules.
+func GetCategories() []string {
+	return categories
+}
+
+func getCategory(
+	mimeType string,
+) string {
+	switch mimeType[:strings.Index(mimeType, "/")] {
+	case "image":
+		return "image"
+	case "video":
+		return "video"
+	case "audio":
+		return "audio"
+	}
+
+	switch mimeType {
+	case "application/pdf",
+		"application/msword",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.ms-excel",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"text/markdown",
+		"text/x-rst",
+		"text/plain",
+		"application/rtf",
+		"text/html":
+		return "document"
+	case "application/javascript",
+		"text/x-python",
+		"text/x-go",
+		"text/x-rust",
+		"text/x-csharp",
+		"text/x-java-source",
+		"text/css",
+		"text/x-c",
+		"text/x-c++":
+		return "code"
+	case "application/json",
+		"application/x-yaml",
+		"application/toml",
+		"text/ini",
+		"application/xml":
+		return "config"
+	case "application/zip",
+		"application/x-rar-compressed",
+		"application/x-tar",
+		"application/gzip":
+		return "archive"
+	}
+	return "other"
+}
@@ -0,0 +1,319 @@
+package file
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Formats starting with this prefix are evaluated as Go templates.
+const TEMPLATE_PREFIX = "tmpl:"
+
+// The data available to formats in Go template mode. Free text values have
+// their path separators swapped, the raw values are available under Exif.
+type TemplateData struct {
+	// Original path of the file.
+	Path string
+	// Original file name without the extension.
+	Name string
+	// Extension including the leading dot, not added automatically.
+	Ext string
+	// Name of the directory the file is in.
+	Parent string
+	// Date from the EXIF data, or the modification time as a fallback.
+	CreationDate time.Time
+	// Incremental file index.
+	Index int
+	// Mime-type and its short form (image/jpeg and jpeg).
+	MimeType string
+	Type     string
+	// Size in bytes and in a readable form (4.2MB).
+	Size      int64
+	SizeHuman string
+	// Name of the matched place, empty when none matches.
+	Place       string
+	CameraMake  string
+	CameraModel string
+	Exif        Exif
+
+	hashes map[string]string
+}
+
+// Returns the hex digest of the contents, shortened to the optional length.
+// The algorithm has to be written as a constant string, otherwise the digest
+// is not computed and an error is returned.
+func (
+	data TemplateData,
+) Hash(
+	algorithm string,
+	length ...int,
+) (
+	string,
+	error,
+) {
+	digest, ok := data.hashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("no %s digest of the file, write the algorithm as a constant string as in {{.Hash \"md5\"}}", algorithm)
+	}
+	if len(length) > 0 && length[0] > 0 && length[0] < len(digest) {
+		digest = digest[:length[0]]
+	}
+	return digest, nil
+}
+
+// Returns the name of the size bucket, see the %size-bucket% placeholder.
+func (
+	data TemplateData,
+) SizeBucket(
+	specification string,
+) (
+	string,
+	error,
+) {
+	buckets, err := parseSizeBuckets(specification)
+	if err != nil {
+		return "", err
+	}
+	return buckets.bucket(uint64(data.Size)), nil
+}
+
+func newTemplateData(
+	metadata Metadata,
+) TemplateData {
+	base := filepath.Base(metadata.Path)
+	mimeType := getMimeType(metadata.Path)
+
+	return TemplateData{
+		Path:         metadata.Path,
+		Name:         base[:len(base)-len(filepath.Ext(base))],
+		Ext:          filepath.Ext(metadata.Path),
+		Parent:       filepath.Base(filepath.Dir(metadata.Path)),
+		CreationDate: metadata.CreationDate,
+		Index:        metadata.Index,
+		MimeType:     mimeType,
+		Type:         mimeType[strings.LastIndex(mimeType, "/")+1:],
+		Size:         metadata.Size,
+		SizeHuman:    formatSize(uint64(metadata.Size)),
+		Place:        valueSeparatorReplacer.Replace(metadata.Place),
+		CameraMake:   valueSeparatorReplacer.Replace(metadata.Exif.CameraMake),
+		CameraModel:  valueSeparatorReplacer.Replace(metadata.Exif.CameraModel),
+		Exif:         metadata.Exif,
+
+		hashes: metadata.Hashes,
+	}
+}
+
+// The helper functions mirror the filters, taking the value last so they can
+// be used in pipelines such as {{.CameraModel | lower | slug}}.
+var templateFuncs = texttemplate.FuncMap{
+	"lower": func(value any) string {
+		return templateFilter{name: "lower"}.apply(fmt.Sprint(value))
+	},
+	"upper": func(value any) string {
+		return templateFilter{name: "upper"}.apply(fmt.Sprint(value))
+	},
+	"trim": func(value any) string {
+		return templateFilter{name: "trim"}.apply(fmt.Sprint(value))
+	},
+	"slug": func(value any) string {
+		return templateFilter{name: "slug"}.apply(fmt.Sprint(value))
+	},
+	"truncate": func(length int, value any) string {
+		return templateFilter{name: "truncate", arguments: []string{strconv.Itoa(length)}}.apply(fmt.Sprint(value))
+	},
+	"pad": func(length int, value any) string {
+		return templateFilter{name: "pad", arguments: []string{strconv.Itoa(length)}}.apply(fmt.Sprint(value))
+	},
+	"default": func(fallback string, value any) string {
+		return templateFilter{name: "default", arguments: []string{fallback}}.apply(fmt.Sprint(value))
+	},
+	"replace": func(old string, new string, value any) string {
+		return templateFilter{name: "replace", arguments: []string{old, new}}.apply(fmt.Sprint(value))
+	},
+	"date": func(layout string, date time.Time) string {
+		return date.Format(layout)
+	},
+}
+
+// Parses a format in Go template mode and collects the hash algorithms it
+// uses.
+func compileGoTemplate(
+	format string,
+) (
+	*texttemplate.Template,
+	[]string,
+	error,
+) {
+	goTemplate, err := texttemplate.New("format").
+		Funcs(templateFuncs).
+		Option("missingkey=error").
+		Parse(strings.TrimPrefix(format, TEMPLATE_PREFIX))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var algorithms []string
+	var walkErr error
+	// The Hash references that are called directly, any other reference would
+	// only fail while rendering.
+	called := map[parse.Node]bool{}
+	collect := func(node parse.Node) {
+		if walkErr != nil {
+			return
+		}
+
+		command, ok := node.(*parse.CommandNode)
+		if !ok || len(command.Args) == 0 || !callsHash(command.Args[0]) {
+			if referencesHash(node) && !called[node] {
+				walkErr = fmt.Errorf(".Hash has to be called directly with the algorithm as a constant string, as in {{.Hash \"md5\"}}")
+			}
+			return
+		}
+		called[command.Args[0]] = true
+
+		if len(command.Args) < 2 {
+			walkErr = fmt.Errorf(".Hash needs an algorithm")
+			return
+		}
+		algorithm, ok := command.Args[1].(*parse.StringNode)
+		if !ok {
+			walkErr = fmt.Errorf(".Hash needs the algorithm as a constant string")
+			return
+		}
+		if _, ok := hashAlgorithms[algorithm.Text]; !ok {
+			walkErr = fmt.Errorf("unknown hash algorithm %q", algorithm.Text)
+			return
+		}
+		if len(command.Args) > 3 {
+			walkErr = fmt.Errorf(".Hash takes an algorithm and an optional length")
+			return
+		}
+		if len(command.Args) == 3 {
+			if length, ok := command.Args[2].(*parse.NumberNode); !ok || !length.IsInt {
+				walkErr = fmt.Errorf(".Hash needs the length as a constant number")
+				return
+			}
+		}
+
+		if !slices.Contains(algorithms, algorithm.Text) {
+			algorithms = append(algorithms, algorithm.Text)
+		}
+	}
+	// Templates defined in the format are walked as well, as they can be
+	// called from it.
+	for _, definition := range goTemplate.Templates() {
+		walkTemplate(definition.Root, collect)
+	}
+	if walkErr != nil {
+		return nil, nil, walkErr
+	}
+
+	return goTemplate, algorithms, nil
+}
+
+// Returns the field names of the node, as Hash in .Hash or $data.Hash.
+func fieldNames(
+	node parse.Node,
+) []string {
+	switch node := node.(type) {
+	case *parse.FieldNode:
+		return node.Ident
+	case *parse.VariableNode:
+		return node.Ident
+	case *parse.ChainNode:
+		return node.Field
+	}
+	return nil
+}
+
+// Reports whether the node calls the Hash method, on the data as in .Hash or
+// on a variable holding it as in $data.Hash.
+func callsHash(
+	node parse.Node,
+) bool {
+	names := fieldNames(node)
+	return len(names) > 0 && names[len(names)-1] == "Hash"
+}
+
+// Reports whether the node refers to the Hash method anywhere, such as in
+// .Hash.Field.
+func referencesHash(
+	node parse.Node,
+) bool {
+	return slices.Contains(fieldNames(node), "Hash")
+}
+
+// Calls the function for every node in the template tree, commands before
+// their arguments.
+func walkTemplate(
+	node parse.Node,
+	function func(parse.Node),
+) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			walkTemplate(child, function)
+		}
+		return
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+	}
+
+	function(node)
+	switch node := node.(type) {
+	case *parse.ActionNode:
+		walkTemplate(node.Pipe, function)
+	case *parse.TemplateNode:
+		walkTemplate(node.Pipe, function)
+	case *parse.PipeNode:
+		for _, variable := range node.Decl {
+			walkTemplate(variable, function)
+		}
+		for _, command := range node.Cmds {
+			walkTemplate(command, function)
+		}
+	case *parse.CommandNode:
+		for _, argument := range node.Args {
+			walkTemplate(argument, function)
+		}
+	case *parse.ChainNode:
+		walkTemplate(node.Node, function)
+	case *parse.IfNode:
+		walkTemplate(node.Pipe, function)
+		walkTemplate(node.List, function)
+		walkTemplate(node.ElseList, function)
+	case *parse.RangeNode:
+		walkTemplate(node.Pipe, function)
+		walkTemplate(node.List, function)
+		walkTemplate(node.ElseList, function)
+	case *parse.WithNode:
+		walkTemplate(node.Pipe, function)
+		walkTemplate(node.List, function)
+		walkTemplate(node.ElseList, function)
+	}
+}
+
+// Executes a format in Go template mode, surrounding whitespace is removed.
+func renderGoTemplate(
+	goTemplate *texttemplate.Template,
+	metadata Metadata,
+) (
+	string,
+	error,
+) {
+	var name strings.Builder
+	if err := goTemplate.Execute(&name, newTemplateData(metadata)); err != nil {
+		return "", fmt.Errorf("failed to render format for file %s: %w", metadata.Path, err)
+	}
+	return strings.TrimSpace(name.String()), nil
+}
@@ -0,0 +1,48 @@
+package file
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompileGoTemplateHash(
+	t *testing.T,
+) {
+	tests := []struct {
+		format     string
+		algorithms []string
+		valid      bool
+	}{
+		{format: `tmpl:{{.Hash "md5"}}`, algorithms: []string{"md5"}, valid: true},
+		{format: `tmpl:{{.Hash "sha256" 12}}`, algorithms: []string{"sha256"}, valid: true},
+		{format: `tmpl:{{with $data := .}}{{$data.Hash "sha1"}}{{end}}`, algorithms: []string{"sha1"}, valid: true},
+		{format: `tmpl:{{define "h"}}{{.Hash "xxh64"}}{{end}}{{template "h" .}}`, algorithms: []string{"xxh64"}, valid: true},
+		{format: `tmpl:{{.Name | lower}}`, valid: true},
+		{format: `tmpl:{{.Hash}}`},
+		{format: `tmpl:{{.Hash .Name}}`},
+		{format: `tmpl:{{.Hash "md4"}}`},
+		{format: `tmpl:{{.Hash "md5" "8"}}`},
+		{format: `tmpl:{{call .Hash "md5"}}`},
+		{format: `tmpl:{{$h := .Hash}}{{$h}}`},
+		{format: `tmpl:{{"md5" | .Hash}}`},
+		{format: `tmpl:{{printf "%s" (.Hash)}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			_, algorithms, err := compileGoTemplate(test.format)
+			if !test.valid {
+				if err == nil {
+					t.Fatal("expected the format to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(algorithms, test.algorithms) {
+				t.Errorf("expected algorithms %v, got %v", test.algorithms, algorithms)
+			}
+		})
+	}
+}
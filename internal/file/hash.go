@@ -0,0 +1,126 @@
+package file
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+const HASH_DEFAULT = "sha256"
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake3": func() hash.Hash { return blake3.New() },
+	"xxh64":  func() hash.Hash { return xxhash.New() },
+}
+
+// Parses the optional algorithm and length arguments of a hash placeholder.
+func parseHashArguments(
+	arguments []string,
+) (
+	string,
+	int,
+	error,
+) {
+	if len(arguments) > 2 {
+		return "", 0, fmt.Errorf("placeholder %%hash%% takes at most an algorithm and a length")
+	}
+
+	algorithm := HASH_DEFAULT
+	if len(arguments) > 0 && arguments[0] != "" {
+		algorithm = arguments[0]
+	}
+	if _, ok := hashAlgorithms[algorithm]; !ok {
+		return "", 0, fmt.Errorf("unknown hash algorithm %q", algorithm)
+	}
+
+	length := 0
+	if len(arguments) > 1 {
+		var err error
+		length, err = strconv.Atoi(arguments[1])
+		if err != nil || length <= 0 {
+			return "", 0, fmt.Errorf("invalid hash length %q", arguments[1])
+		}
+	}
+	return algorithm, length, nil
+}
+
+// Creates a writer feeding every requested hash algorithm at once.
+func newHashWriter(
+	algorithms []string,
+) (
+	io.Writer,
+	func() map[string]string,
+) {
+	hashers := make(map[string]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		hasher := hashAlgorithms[algorithm]()
+		hashers[algorithm] = hasher
+		writers = append(writers, hasher)
+	}
+
+	return io.MultiWriter(writers...), func() map[string]string {
+		digests := make(map[string]string, len(hashers))
+		for algorithm, hasher := range hashers {
+			digests[algorithm] = hex.EncodeToString(hasher.Sum(nil))
+		}
+		return digests
+	}
+}
+
+// Computes the hex encoded digests of a file in a single read.
+func HashFile(
+	path string,
+	algorithms []string,
+) (
+	map[string]string,
+	error,
+) {
+	sourceFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer sourceFile.Close()
+
+	writer, digests := newHashWriter(algorithms)
+	if _, err := io.Copy(writer, sourceFile); err != nil {
+		return nil, fmt.Errorf("failed to hash file contents: %w", err)
+	}
+	return digests(), nil
+}
+
+// Returns the hex digest of the first bytes of the file, a cheap way to tell
+// files of the same size apart.
+func HashFileStart(
+	path string,
+	length int64,
+) (
+	string,
+	error,
+) {
+	sourceFile, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer sourceFile.Close()
+
+	writer, digests := newHashWriter([]string{HASH_DEFAULT})
+	if _, err := io.CopyN(writer, sourceFile, length); err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to hash file contents: %w", err)
+	}
+	return digests()[HASH_DEFAULT], nil
+}
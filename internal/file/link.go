@@ -0,0 +1,66 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Creates a hard link to the file at the destination. Hard links can not span
+// file systems, the file is not copied instead.
+func HardlinkFile(
+	path string,
+	destinationPath string,
+) error {
+	return placeLink(destinationPath, func(temporaryPath string) error {
+		err := os.Link(path, temporaryPath)
+		if isCrossDevice(err) {
+			return errors.New("can not hardlink across file systems")
+		}
+		return err
+	})
+}
+
+// Creates a symbolic link to the file at the destination, pointing to its
+// absolute path or to its path relative to the destination directory.
+func SymlinkFile(
+	path string,
+	destinationPath string,
+	relative bool,
+) error {
+	target, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if relative {
+		target, err = filepath.Rel(filepath.Dir(destinationPath), target)
+		if err != nil {
+			return fmt.Errorf("failed to make link relative: %w", err)
+		}
+	}
+
+	return placeLink(destinationPath, func(temporaryPath string) error {
+		return os.Symlink(target, temporaryPath)
+	})
+}
+
+// Creates the link under a temporary name next to the destination and renames
+// it into place, so an existing destination is replaced atomically.
+func placeLink(
+	destinationPath string,
+	link func(temporaryPath string) error,
+) error {
+	temporaryFile, err := os.CreateTemp(filepath.Dir(destinationPath), TEMPORARY_PATTERN)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	temporaryPath := temporaryFile.Name()
+	temporaryFile.Close()
+	os.Remove(temporaryPath)
+
+	if err := link(temporaryPath); err != nil {
+		return fmt.Errorf("failed to create link: %w", err)
+	}
+	return CommitFile(temporaryPath, destinationPath)
+}
@@ -0,0 +1,122 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// How often a copy is made before giving up when it does not match the source.
+const VERIFY_ATTEMPTS = 3
+
+// Returned when a copy still does not match the source after every attempt.
+var ErrVerificationFailed = errors.New("copy does not match the source file")
+
+// Renames the file to the destination. When the destination is on another
+// file system the file is copied with all its metadata to a temporary file
+// instead, which only replaces the destination once it is verified. A copy
+// that does not match is made again and the source and destination are left
+// as they were when none match. The permissions are set on the moved file,
+// the source is never changed. Returns whether the file had to be copied and
+// the digest of its source.
+func MoveFile(
+	path string,
+	destinationPath string,
+	copyMode string,
+	permissions Permissions,
+) (
+	bool,
+	string,
+	error,
+) {
+	err := os.Rename(path, destinationPath)
+	if err == nil {
+		return false, "", permissions.Apply(destinationPath, false)
+	}
+	if !isCrossDevice(err) {
+		return false, "", err
+	}
+
+	digest, err := moveByCopy(path, destinationPath, copyMode, permissions)
+	return true, digest, err
+}
+
+// Called with the temporary copy before it is verified, tests use it to
+// damage the copy.
+var beforeVerify func(temporaryPath string)
+
+// Copies the file to a temporary file next to the destination and compares it
+// to the digest of the source read while copying. Only a verified copy is
+// renamed into place, after which the source is removed.
+func moveByCopy(
+	path string,
+	destinationPath string,
+	copyMode string,
+	permissions Permissions,
+) (
+	string,
+	error,
+) {
+	var err error
+	temporaryPath := ""
+	digest := ""
+	for attempt := 0; attempt < VERIFY_ATTEMPTS; attempt++ {
+		var digests map[string]string
+		temporaryPath, digests, err = CopyToTemporary(path, filepath.Dir(destinationPath), CopyOptions{Preserve: PreserveAll(), Mode: copyMode}, HASH_DEFAULT)
+		if err != nil {
+			return "", err
+		}
+		digest = digests[HASH_DEFAULT]
+		if beforeVerify != nil {
+			beforeVerify(temporaryPath)
+		}
+		err = verifyCopy(temporaryPath, digest)
+		if err == nil {
+			break
+		}
+		os.Remove(temporaryPath)
+	}
+	if errors.Is(err, ErrVerificationFailed) {
+		return "", fmt.Errorf("%w %s after %d attempts", ErrVerificationFailed, path, VERIFY_ATTEMPTS)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := permissions.Apply(temporaryPath, false); err != nil {
+		os.Remove(temporaryPath)
+		return "", err
+	}
+	if err := CommitFile(temporaryPath, destinationPath); err != nil {
+		return "", err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return digest, fmt.Errorf("failed to remove source file after copying: %w", err)
+	}
+	return digest, nil
+}
+
+// Reads the written file back from disk, bypassing the page cache where
+// possible, and compares its hash to the digest of the source.
+func verifyCopy(
+	copyPath string,
+	digest string,
+) error {
+	destinationFile, err := os.Open(copyPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy: %w", err)
+	}
+	defer destinationFile.Close()
+	dropCache(destinationFile)
+
+	writer, digests := newHashWriter([]string{HASH_DEFAULT})
+	if _, err := io.Copy(writer, destinationFile); err != nil {
+		return fmt.Errorf("failed to verify copy: %w", err)
+	}
+	if digests()[HASH_DEFAULT] != digest {
+		return ErrVerificationFailed
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package file
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isCrossDevice(
+	err error,
+) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
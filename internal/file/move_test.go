@@ -0,0 +1,85 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveByCopy(
+	t *testing.T,
+) {
+	contents := []byte("contents of the source file")
+	tests := []struct {
+		name    string
+		mode    string
+		corrupt bool
+	}{
+		{name: "auto", mode: COPY_MODE_AUTO},
+		{name: "userspace", mode: COPY_MODE_USERSPACE},
+		{name: "corrupted auto", mode: COPY_MODE_AUTO, corrupt: true},
+		{name: "corrupted userspace", mode: COPY_MODE_USERSPACE, corrupt: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			path := filepath.Join(directory, "source.txt")
+			destinationPath := filepath.Join(directory, "destination", "source.txt")
+			if err := os.WriteFile(path, contents, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Dir(destinationPath), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			attempts := 0
+			beforeVerify = func(temporaryPath string) {
+				attempts++
+				if test.corrupt {
+					if err := os.WriteFile(temporaryPath, []byte("damaged"), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			defer func() { beforeVerify = nil }()
+
+			digest, err := moveByCopy(path, destinationPath, test.mode, Permissions{})
+
+			if test.corrupt {
+				if !errors.Is(err, ErrVerificationFailed) {
+					t.Fatalf("expected %v, got %v", ErrVerificationFailed, err)
+				}
+				if attempts != VERIFY_ATTEMPTS {
+					t.Errorf("expected %d attempts, got %d", VERIFY_ATTEMPTS, attempts)
+				}
+				if source, err := os.ReadFile(path); err != nil || string(source) != string(contents) {
+					t.Errorf("source was not kept: %q, %v", source, err)
+				}
+				if _, err := os.Stat(destinationPath); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("destination should not exist: %v", err)
+				}
+				if removed, _ := RemoveTemporaryFiles(filepath.Dir(destinationPath)); removed != 0 {
+					t.Errorf("%d temporary files were left behind", removed)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("source should be removed: %v", err)
+			}
+			if destination, err := os.ReadFile(destinationPath); err != nil || string(destination) != string(contents) {
+				t.Errorf("destination does not hold the source: %q, %v", destination, err)
+			}
+			writer, digests := newHashWriter([]string{HASH_DEFAULT})
+			writer.Write(contents)
+			if expected := digests()[HASH_DEFAULT]; digest != expected {
+				t.Errorf("expected digest %s, got %s", expected, digest)
+			}
+		})
+	}
+}
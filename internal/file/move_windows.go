@@ -0,0 +1,17 @@
+//go:build windows
+
+package file
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Returned by MoveFileEx when the destination is on another volume.
+const ERROR_NOT_SAME_DEVICE = syscall.Errno(17)
+
+func isCrossDevice(
+	err error,
+) bool {
+	return errors.Is(err, ERROR_NOT_SAME_DEVICE)
+}
@@ -3,36 +3,358 @@ package file
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
+	texttemplate "text/template"
 	"time"
 )
 
-func FormatName(
+// The metadata of a file available to the format placeholders.
+type Metadata struct {
+	Path         string
+	CreationDate time.Time
+	Index        int
+	Place        string
+	Hashes       map[string]string
+	Size         int64
+	Exif         Exif
+}
+
+// A compiled format, split into literal text and placeholders. In Go template
+// mode it holds the parsed template instead.
+type Template struct {
+	format string
+	parts  []templatePart
+
+	goTemplate     *texttemplate.Template
+	hashAlgorithms []string
+}
+
+// Either literal text or a placeholder with its colon separated arguments and
+// the filters applied to its value.
+type templatePart struct {
+	literal     string
+	placeholder string
+	arguments   []string
+	filters     []templateFilter
+}
+
+// Free text values can not add directories, their path separators are swapped.
+var valueSeparatorReplacer = strings.NewReplacer("/", "_", "\\", "_")
+
+// The placeholders known to the format and whether they take arguments.
+var placeholderArguments = map[string]bool{
+	"year":         false,
+	"month":        false,
+	"day":          false,
+	"hour":         false,
+	"minute":       false,
+	"second":       false,
+	"index":        false,
+	"ext":          false,
+	"type":         false,
+	"mime-type":    false,
+	"place":        false,
+	"camera-make":  false,
+	"camera-model": false,
+	"name":         false,
+	"parent":       false,
+	"hash":         true,
+	"size":         false,
+	"size-human":   false,
+	"size-bucket":  true,
+}
+
+// Parses a format and checks its placeholders and filters. A literal percent
+// sign is written as %%. When the format has no %ext% placeholder it is
+// appended. Formats starting with the template prefix are parsed as Go
+// templates instead.
+func CompileFormat(
 	format string,
-	creationDate time.Time,
-	index int,
-	originalPath string,
+) (
+	*Template,
+	error,
+) {
+	if strings.HasPrefix(format, TEMPLATE_PREFIX) {
+		goTemplate, algorithms, err := compileGoTemplate(format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template in format %q: %w", format, err)
+		}
+		return &Template{
+			format:         format,
+			goTemplate:     goTemplate,
+			hashAlgorithms: algorithms,
+		}, nil
+	}
+
+	if filepath.IsAbs(format) || strings.HasPrefix(format, "/") || filepath.VolumeName(format) != "" {
+		return nil, fmt.Errorf("format %q should be a relative path", format)
+	}
+
+	template := &Template{
+		format: format,
+	}
+
+	hasExt := false
+	rest := format
+	for rest != "" {
+		start := strings.IndexByte(rest, '%')
+		if start < 0 {
+			template.addLiteral(rest)
+			break
+		}
+		template.addLiteral(rest[:start])
+
+		length := strings.IndexByte(rest[start+1:], '%')
+		if length < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in format %q", format)
+		}
+		token := rest[start+1 : start+1+length]
+		rest = rest[start+2+length:]
+
+		if token == "" {
+			template.addLiteral("%")
+			continue
+		}
+
+		part, err := parsePlaceholder(token)
+		if err != nil {
+			return nil, fmt.Errorf("%w in format %q", err, format)
+		}
+		if part.placeholder == "ext" {
+			hasExt = true
+		}
+		template.parts = append(template.parts, part)
+	}
+
+	if !hasExt {
+		template.parts = append(template.parts, templatePart{placeholder: "ext"})
+	}
+
+	// Placeholders are marked so only segments written as .. are caught here,
+	// rendered paths are checked again.
+	var skeleton strings.Builder
+	for _, part := range template.parts {
+		if part.placeholder != "" {
+			skeleton.WriteString("%")
+		}
+		skeleton.WriteString(filepath.ToSlash(part.literal))
+	}
+	if slices.Contains(strings.Split(skeleton.String(), "/"), "..") {
+		return nil, fmt.Errorf("format %q should not leave the destination directory with ..", format)
+	}
+	return template, nil
+}
+
+func parsePlaceholder(
+	token string,
+) (
+	templatePart,
+	error,
+) {
+	pipeline := strings.Split(token, "|")
+	fields := strings.Split(pipeline[0], ":")
+	part := templatePart{
+		placeholder: fields[0],
+		arguments:   fields[1:],
+	}
+
+	for _, filterToken := range pipeline[1:] {
+		filter, err := parseFilter(filterToken)
+		if err != nil {
+			return templatePart{}, err
+		}
+		part.filters = append(part.filters, filter)
+	}
+
+	takesArguments, ok := placeholderArguments[part.placeholder]
+	if !ok {
+		return templatePart{}, fmt.Errorf("unknown placeholder %%%s%%", part.placeholder)
+	}
+	if !takesArguments && len(part.arguments) > 0 {
+		return templatePart{}, fmt.Errorf("placeholder %%%s%% takes no arguments", part.placeholder)
+	}
+
+	switch part.placeholder {
+	case "hash":
+		if _, _, err := parseHashArguments(part.arguments); err != nil {
+			return templatePart{}, err
+		}
+	case "size-bucket":
+		if len(part.arguments) > 1 {
+			return templatePart{}, fmt.Errorf("placeholder %%size-bucket%% takes a single argument")
+		}
+		if _, err := parseSizeBuckets(strings.Join(part.arguments, "")); err != nil {
+			return templatePart{}, err
+		}
+	}
+	return part, nil
+}
+
+func (
+	template *Template,
+) addLiteral(
+	literal string,
+) {
+	if literal != "" {
+		template.parts = append(template.parts, templatePart{literal: literal})
+	}
+}
+
+// Returns the format the template was compiled from.
+func (
+	template *Template,
+) String() string {
+	return template.format
+}
+
+// Returns the hash algorithms used by the template, without duplicates.
+func (
+	template *Template,
+) HashAlgorithms() []string {
+	if template.goTemplate != nil {
+		return template.hashAlgorithms
+	}
+
+	var algorithms []string
+	seen := map[string]bool{}
+	for _, part := range template.parts {
+		if part.placeholder != "hash" {
+			continue
+		}
+
+		algorithm, _, _ := parseHashArguments(part.arguments)
+		if !seen[algorithm] {
+			seen[algorithm] = true
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms
+}
+
+// Renders the relative path of a file. Path segments that render empty or as
+// . are dropped and, outside of Go template mode, segments rendering as .. are
+// swapped for underscores. Fails when the path would end up outside of the
+// directory it is joined with.
+func FormatName(
+	template *Template,
+	metadata Metadata,
+) (
+	string,
+	error,
+) {
+	var name string
+	if template.goTemplate != nil {
+		var err error
+		name, err = renderGoTemplate(template.goTemplate, metadata)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		name = renderParts(template.parts, metadata)
+	}
+
+	segments := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '/' || r == filepath.Separator
+	})
+	// Segments of a single dot point at the same directory and are dropped
+	// like empty ones. Formats can not contain .. segments, so these come from
+	// placeholder values and have their dots swapped.
+	segments = slices.DeleteFunc(segments, func(segment string) bool {
+		return segment == "."
+	})
+	if template.goTemplate == nil {
+		for index, segment := range segments {
+			if segment == ".." {
+				segments[index] = "__"
+			}
+		}
+	}
+	rendered := strings.Join(segments, "/")
+	if rendered == "" {
+		return "", fmt.Errorf("rendered path for file %s is empty", metadata.Path)
+	}
+	// A .. segment is rejected even when the path stays within the
+	// destination, as in a/.., since it would not name a file.
+	if slices.Contains(segments, "..") || !filepath.IsLocal(rendered) {
+		return "", fmt.Errorf("rendered path %q for file %s escapes the destination directory", rendered, metadata.Path)
+	}
+	return rendered, nil
+}
+
+func renderParts(
+	parts []templatePart,
+	metadata Metadata,
+) string {
+	var name strings.Builder
+	for _, part := range parts {
+		if part.placeholder == "" {
+			name.WriteString(part.literal)
+			continue
+		}
+
+		value := renderPlaceholder(part, metadata)
+		for _, filter := range part.filters {
+			value = filter.apply(value)
+		}
+		name.WriteString(value)
+	}
+	return name.String()
+}
+
+func renderPlaceholder(
+	part templatePart,
+	metadata Metadata,
 ) string {
-	if !strings.Contains(format, "%ext%") {
-		format += "%ext%"
-	}
-
-	ext := filepath.Ext(originalPath)
-	mimeType := getMimeType(originalPath)
-	mimeTypeShort := mimeType[strings.LastIndex(mimeType, "/")+1:]
-
-	replacer := strings.NewReplacer(
-		"%year%", creationDate.Format("2006"),
-		"%month%", creationDate.Format("01"),
-		"%day%", creationDate.Format("02"),
-		"%hour%", creationDate.Format("15"),
-		"%minute%", creationDate.Format("04"),
-		"%second%", creationDate.Format("05"),
-		"%index%", fmt.Sprintf("%d", index),
-		"%type%", mimeTypeShort,
-		"%mime-type%", mimeType,
-		"%ext%", ext,
-	)
-
-	return replacer.Replace(format)
+	creationDate := metadata.CreationDate
+
+	switch part.placeholder {
+	case "year":
+		return creationDate.Format("2006")
+	case "month":
+		return creationDate.Format("01")
+	case "day":
+		return creationDate.Format("02")
+	case "hour":
+		return creationDate.Format("15")
+	case "minute":
+		return creationDate.Format("04")
+	case "second":
+		return creationDate.Format("05")
+	case "index":
+		return fmt.Sprintf("%d", metadata.Index)
+	case "ext":
+		return filepath.Ext(metadata.Path)
+	case "type":
+		mimeType := getMimeType(metadata.Path)
+		return mimeType[strings.LastIndex(mimeType, "/")+1:]
+	case "mime-type":
+		return getMimeType(metadata.Path)
+	case "place":
+		return valueSeparatorReplacer.Replace(metadata.Place)
+	case "camera-make":
+		return valueSeparatorReplacer.Replace(metadata.Exif.CameraMake)
+	case "camera-model":
+		return valueSeparatorReplacer.Replace(metadata.Exif.CameraModel)
+	case "name":
+		base := filepath.Base(metadata.Path)
+		return base[:len(base)-len(filepath.Ext(base))]
+	case "parent":
+		return filepath.Base(filepath.Dir(metadata.Path))
+	case "hash":
+		algorithm, length, _ := parseHashArguments(part.arguments)
+		digest := metadata.Hashes[algorithm]
+		if length > 0 && length < len(digest) {
+			digest = digest[:length]
+		}
+		return digest
+	case "size":
+		return fmt.Sprintf("%d", metadata.Size)
+	case "size-human":
+		return formatSize(uint64(metadata.Size))
+	case "size-bucket":
+		buckets, _ := parseSizeBuckets(strings.Join(part.arguments, ""))
+		return buckets.bucket(uint64(metadata.Size))
+	}
+	return ""
 }
@@ -0,0 +1,142 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompileFormat(
+	t *testing.T,
+) {
+	tests := []struct {
+		format string
+		valid  bool
+	}{
+		{format: "%year%/%month%/%name%", valid: true},
+		{format: "./%year%/%name%", valid: true},
+		{format: "%name|replace:a:b%", valid: true},
+		{format: "%place|default:unknown-place%/%name%", valid: true},
+		{format: "100%%/%name%", valid: true},
+		{format: "/absolute/%name%"},
+		{format: "../%name%"},
+		{format: "%year%/../../%name%"},
+		{format: "%year%/..%"},
+		{format: "%unknown%"},
+		{format: "%name"},
+		{format: "%name:argument%"},
+		{format: "%name|unknown%"},
+		{format: "%name|replace:a:/..%"},
+		{format: "%place|default:../y%"},
+		{format: `%place|default:..\y%`},
+		{format: "%name|truncate:0%"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			_, err := CompileFormat(test.format)
+			if test.valid && err != nil {
+				t.Fatalf("expected the format to compile: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected the format to be rejected")
+			}
+		})
+	}
+}
+
+func TestFormatName(
+	t *testing.T,
+) {
+	creationDate := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		format   string
+		metadata Metadata
+		expected string
+	}{
+		{
+			name:     "date",
+			format:   "%year%/%month%/%day%/%name%",
+			metadata: Metadata{Path: "/in/photo.jpg", CreationDate: creationDate},
+			expected: "2024/03/15/photo.jpg",
+		},
+		{
+			name:     "empty segment",
+			format:   "%camera-model%/%name%",
+			metadata: Metadata{Path: "/in/photo.jpg"},
+			expected: "photo.jpg",
+		},
+		{
+			name:     "default",
+			format:   "%place|default:unknown-place%/%name%",
+			metadata: Metadata{Path: "/in/photo.jpg"},
+			expected: "unknown-place/photo.jpg",
+		},
+		{
+			name:     "separators in values",
+			format:   "%place%/%name%",
+			metadata: Metadata{Path: "/in/photo.jpg", Place: "../etc/passwd"},
+			expected: ".._etc_passwd/photo.jpg",
+		},
+		{
+			name:     "parent segment from value",
+			format:   "%place%/%name%",
+			metadata: Metadata{Path: "/in/photo.jpg", Place: ".."},
+			expected: "__/photo.jpg",
+		},
+		{
+			name:     "parent segment from filter",
+			format:   "%place|default:..%/%name%",
+			metadata: Metadata{Path: "/in/photo.jpg"},
+			expected: "__/photo.jpg",
+		},
+		{
+			name:     "dots within a segment",
+			format:   "%name%%ext%",
+			metadata: Metadata{Path: "/in/foo."},
+			expected: "foo.",
+		},
+		{
+			name:     "filters",
+			format:   "%camera-model|lower|slug%/%name|upper|truncate:3%",
+			metadata: Metadata{Path: "/in/photo.jpg", Exif: Exif{CameraModel: "Canon EOS R5"}},
+			expected: "canon-eos-r5/PHO.jpg",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template, err := CompileFormat(test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rendered, err := FormatName(template, test.metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, rendered)
+			}
+		})
+	}
+}
+
+func TestFormatNameEscapingTemplate(
+	t *testing.T,
+) {
+	for _, format := range []string{
+		`tmpl:../{{.Name}}`,
+		`tmpl:{{.Name}}/../../x`,
+		`tmpl:/{{.Name}}/..`,
+	} {
+		t.Run(format, func(t *testing.T) {
+			template, err := CompileFormat(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered, err := FormatName(template, Metadata{Path: "/in/photo.jpg"}); err == nil {
+				t.Errorf("expected the path to be rejected, got %q", rendered)
+			}
+		})
+	}
+}
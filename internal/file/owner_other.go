@@ -0,0 +1,26 @@
+//go:build !unix
+
+package file
+
+import (
+	"os"
+)
+
+// Ownership is only known on Unix systems.
+func fileOwner(
+	info os.FileInfo,
+) (
+	int,
+	int,
+	bool,
+) {
+	return 0, 0, false
+}
+
+// Ownership is only copied on Unix systems.
+func copyOwner(
+	info os.FileInfo,
+	destinationPath string,
+) error {
+	return nil
+}
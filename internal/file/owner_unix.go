@@ -0,0 +1,33 @@
+//go:build unix
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileOwner(
+	info os.FileInfo,
+) (
+	int,
+	int,
+	bool,
+) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+func copyOwner(
+	info os.FileInfo,
+	destinationPath string,
+) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(destinationPath, int(stat.Uid), int(stat.Gid))
+}
@@ -0,0 +1,207 @@
+package file
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Perceptual hash algorithms, dhash produces 64 bits and phash 63.
+const (
+	// Compares the brightness of neighbouring pixels of a 9 by 8 thumbnail.
+	PERCEPTUAL_DHASH = "dhash"
+	// Compares the low frequencies of a 32 by 32 thumbnail to their median.
+	PERCEPTUAL_PHASH = "phash"
+)
+
+var perceptualAlgorithms = []string{
+	PERCEPTUAL_DHASH,
+	PERCEPTUAL_PHASH,
+}
+
+// Returns the available perceptual hash algorithms, the first is the default.
+func GetPerceptualAlgorithms() []string {
+	return perceptualAlgorithms
+}
+
+// Checks whether the value is a known perceptual hash algorithm.
+func ParsePerceptualAlgorithm(
+	value string,
+) (
+	string,
+	error,
+) {
+	if !slices.Contains(perceptualAlgorithms, value) {
+		return "", fmt.Errorf("unknown perceptual hash algorithm %q, expected one of %s", value, strings.Join(perceptualAlgorithms, ", "))
+	}
+	return value, nil
+}
+
+// Whether the file is an image format that can be decoded for perceptual
+// hashing.
+func IsDecodableImage(
+	path string,
+) bool {
+	switch getMimeType(path) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// The perceptual hash of an image and its resolution.
+type PerceptualHash struct {
+	Hash   uint64
+	Width  int
+	Height int
+}
+
+// Decodes the image and hashes it with the algorithm. Similar images have
+// hashes that differ in few bits, see HammingDistance.
+func HashImage(
+	path string,
+	algorithm string,
+) (
+	PerceptualHash,
+	error,
+) {
+	imageFile, err := os.Open(path)
+	if err != nil {
+		return PerceptualHash{}, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer imageFile.Close()
+
+	decoded, _, err := image.Decode(imageFile)
+	if err != nil {
+		return PerceptualHash{}, fmt.Errorf("failed to decode image %s: %w", path, err)
+	}
+
+	result := PerceptualHash{
+		Width:  decoded.Bounds().Dx(),
+		Height: decoded.Bounds().Dy(),
+	}
+	if algorithm == PERCEPTUAL_PHASH {
+		result.Hash = pHash(decoded)
+	} else {
+		result.Hash = dHash(decoded)
+	}
+	return result, nil
+}
+
+// Returns the number of bits two hashes differ in.
+func HammingDistance(
+	a uint64,
+	b uint64,
+) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func dHash(
+	decoded image.Image,
+) uint64 {
+	pixels := thumbnail(decoded, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if pixels[y][x] < pixels[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func pHash(
+	decoded image.Image,
+) uint64 {
+	const size = 32
+	pixels := thumbnail(decoded, size, size)
+
+	// Separable DCT-II, first over the rows then over the columns. Only the
+	// top left 8 by 8 frequencies are needed.
+	cosines := make([][]float64, 8)
+	for u := range cosines {
+		cosines[u] = make([]float64, size)
+		for x := range cosines[u] {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+	rows := make([][]float64, size)
+	for y := range rows {
+		rows[y] = make([]float64, 8)
+		for u := 0; u < 8; u++ {
+			for x := 0; x < size; x++ {
+				rows[y][u] += pixels[y][x] * cosines[u][x]
+			}
+		}
+	}
+	frequencies := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				sum += rows[y][u] * cosines[v][y]
+			}
+			frequencies = append(frequencies, sum)
+		}
+	}
+
+	// The first frequency is the average brightness, it is left out so only
+	// the 63 others are compared to their median.
+	sorted := slices.Clone(frequencies[1:])
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for _, frequency := range frequencies[1:] {
+		hash <<= 1
+		if frequency > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// Scales the image down to a grayscale thumbnail, averaging the pixels that
+// fall into each cell.
+func thumbnail(
+	decoded image.Image,
+	width int,
+	height int,
+) [][]float64 {
+	bounds := decoded.Bounds()
+	sums := make([][]float64, height)
+	counts := make([][]int, height)
+	for y := range sums {
+		sums[y] = make([]float64, width)
+		counts[y] = make([]int, width)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cellY := (y - bounds.Min.Y) * height / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cellX := (x - bounds.Min.X) * width / bounds.Dx()
+			r, g, b, _ := decoded.At(x, y).RGBA()
+			sums[cellY][cellX] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cellY][cellX]++
+		}
+	}
+
+	for y := range sums {
+		for x := range sums[y] {
+			if counts[y][x] > 0 {
+				sums[y][x] /= float64(counts[y][x])
+			}
+		}
+	}
+	return sums
+}
@@ -0,0 +1,181 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Modes and ownership given to created files and directories, overriding the
+// umask and preserved metadata.
+type Permissions struct {
+	// Modes of files and directories, left as created when zero.
+	FileMode      os.FileMode `json:"file_mode,omitempty"`
+	DirectoryMode os.FileMode `json:"directory_mode,omitempty"`
+
+	// User and group ids, only changed when set.
+	Uid      int  `json:"uid"`
+	Gid      int  `json:"gid"`
+	SetOwner bool `json:"set_owner,omitempty"`
+	SetGroup bool `json:"set_group,omitempty"`
+}
+
+// Returns the mode and, on Unix systems, the ownership of the file, so they
+// can be set on it again.
+func FilePermissions(
+	info os.FileInfo,
+) Permissions {
+	permissions := Permissions{
+		FileMode: info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky),
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		permissions.Uid = uid
+		permissions.Gid = gid
+		permissions.SetOwner = true
+		permissions.SetGroup = true
+	}
+	return permissions
+}
+
+// Parses the modes as octal numbers, "664" for both files and directories or
+// "F664,D2775" for each separately.
+func ParseChmod(
+	value string,
+	permissions *Permissions,
+) error {
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		target := ""
+		if part[0] == 'F' || part[0] == 'D' {
+			target, part = part[:1], part[1:]
+		}
+		bits, err := strconv.ParseUint(part, 8, 32)
+		if err != nil || bits > 0o7777 {
+			return fmt.Errorf("invalid mode %q, expected an octal mode such as 664 optionally prefixed with F or D", part)
+		}
+
+		mode := octalMode(uint32(bits))
+		if target != "D" {
+			permissions.FileMode = mode
+		}
+		if target != "F" {
+			permissions.DirectoryMode = mode
+		}
+	}
+	return nil
+}
+
+// Parses the owner as "user:group", "user" or ":group", by name or by id.
+func ParseChown(
+	value string,
+	permissions *Permissions,
+) error {
+	if value == "" {
+		return nil
+	}
+
+	owner, group, _ := strings.Cut(value, ":")
+	if owner != "" {
+		uid, err := lookupId(owner, func(name string) (string, error) {
+			found, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return found.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+		permissions.Uid = uid
+		permissions.SetOwner = true
+	}
+	if group != "" {
+		gid, err := lookupId(group, func(name string) (string, error) {
+			found, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return found.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("invalid group %q: %w", group, err)
+		}
+		permissions.Gid = gid
+		permissions.SetGroup = true
+	}
+	return nil
+}
+
+// Sets the ownership and mode of a created file or directory. Ownership goes
+// first as changing it can clear the setuid and setgid bits.
+func (
+	permissions Permissions,
+) Apply(
+	path string,
+	directory bool,
+) error {
+	if permissions.SetOwner || permissions.SetGroup {
+		uid, gid := -1, -1
+		if permissions.SetOwner {
+			uid = permissions.Uid
+		}
+		if permissions.SetGroup {
+			gid = permissions.Gid
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %w", path, err)
+		}
+	}
+
+	mode := permissions.FileMode
+	if directory {
+		mode = permissions.DirectoryMode
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to change mode of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Returns the id of the name, which can also be given as a number.
+func lookupId(
+	name string,
+	lookup func(string) (string, error),
+) (
+	int,
+	error,
+) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// Converts Unix permission bits to a file mode.
+func octalMode(
+	bits uint32,
+) os.FileMode {
+	mode := os.FileMode(bits) & os.ModePerm
+	if bits&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,145 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Metadata of the source file that can be kept on a copy.
+const (
+	PRESERVE_TIMES = "times"
+	PRESERVE_MODE  = "mode"
+	PRESERVE_OWNER = "owner"
+	PRESERVE_XATTR = "xattr"
+)
+
+var preserveAttributes = []string{
+	PRESERVE_TIMES,
+	PRESERVE_MODE,
+	PRESERVE_OWNER,
+	PRESERVE_XATTR,
+}
+
+// Returns the metadata that can be preserved.
+func GetPreserveAttributes() []string {
+	return preserveAttributes
+}
+
+// Which metadata of the source file is kept on a copy. Moved files keep all
+// of it as they are renamed.
+type Preservation struct {
+	// Access and modification times.
+	Times bool
+	// Permission bits.
+	Mode bool
+	// User and group, only possible when running as root.
+	Owner bool
+	// Extended attributes including ACLs, only supported on Linux.
+	Xattr bool
+}
+
+// Returns a preservation of all metadata.
+func PreserveAll() Preservation {
+	return Preservation{
+		Times: true,
+		Mode:  true,
+		Owner: true,
+		Xattr: true,
+	}
+}
+
+// Parses a comma separated list of the metadata to preserve, such as
+// "times,mode". An empty value preserves nothing.
+func ParsePreservation(
+	value string,
+) (
+	Preservation,
+	error,
+) {
+	var preservation Preservation
+	for _, attribute := range strings.Split(value, ",") {
+		attribute = strings.TrimSpace(attribute)
+		switch attribute {
+		case "":
+		case PRESERVE_TIMES:
+			preservation.Times = true
+		case PRESERVE_MODE:
+			preservation.Mode = true
+		case PRESERVE_OWNER:
+			preservation.Owner = true
+		case PRESERVE_XATTR:
+			preservation.Xattr = true
+		default:
+			return Preservation{}, fmt.Errorf("unknown attribute to preserve %q, expected any of %s", attribute, strings.Join(preserveAttributes, ", "))
+		}
+	}
+	return preservation, nil
+}
+
+func (
+	preservation Preservation,
+) String() string {
+	var attributes []string
+	for _, attribute := range preserveAttributes {
+		if preservation.has(attribute) {
+			attributes = append(attributes, attribute)
+		}
+	}
+	return strings.Join(attributes, ",")
+}
+
+func (
+	preservation Preservation,
+) has(
+	attribute string,
+) bool {
+	switch attribute {
+	case PRESERVE_TIMES:
+		return preservation.Times
+	case PRESERVE_MODE:
+		return preservation.Mode
+	case PRESERVE_OWNER:
+		return preservation.Owner
+	case PRESERVE_XATTR:
+		return preservation.Xattr
+	}
+	return false
+}
+
+// Copies the selected metadata of the source file onto the destination. The
+// times are set last as changing the other metadata does not touch them.
+func preserveMetadata(
+	sourcePath string,
+	destinationPath string,
+	preservation Preservation,
+) error {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	if preservation.Xattr {
+		if err := copyExtendedAttributes(sourcePath, destinationPath); err != nil {
+			return fmt.Errorf("failed to preserve extended attributes: %w", err)
+		}
+	}
+	// Changing the owner can clear the setuid and setgid bits, so it goes
+	// before the mode.
+	if preservation.Owner && os.Geteuid() == 0 {
+		if err := copyOwner(info, destinationPath); err != nil {
+			return fmt.Errorf("failed to preserve owner: %w", err)
+		}
+	}
+	if preservation.Mode {
+		if err := os.Chmod(destinationPath, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return fmt.Errorf("failed to preserve permissions: %w", err)
+		}
+	}
+	if preservation.Times {
+		if err := os.Chtimes(destinationPath, accessTime(info), info.ModTime()); err != nil {
+			return fmt.Errorf("failed to preserve times: %w", err)
+		}
+	}
+	return nil
+}
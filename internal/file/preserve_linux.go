@@ -0,0 +1,89 @@
+//go:build linux
+
+package file
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func accessTime(
+	info os.FileInfo,
+) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(stat.Atim.Unix())
+}
+
+// Copies every extended attribute, which includes the ACLs stored as
+// system.posix_acl_access. File systems without support are ignored.
+func copyExtendedAttributes(
+	sourcePath string,
+	destinationPath string,
+) error {
+	names, err := listExtendedAttributes(sourcePath)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		size, err := unix.Getxattr(sourcePath, name, nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, size)
+		size, err = unix.Getxattr(sourcePath, name, value)
+		if err != nil {
+			return err
+		}
+
+		err = unix.Setxattr(destinationPath, name, value[:size], 0)
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			// Not supported by the destination, or a trusted or security
+			// attribute the user is not allowed to set.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listExtendedAttributes(
+	path string,
+) (
+	[]string,
+	error,
+) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buffer := make([]byte, size)
+	size, err = unix.Listxattr(path, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	start := 0
+	for i, b := range buffer[:size] {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buffer[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names, nil
+}
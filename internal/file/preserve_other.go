@@ -0,0 +1,24 @@
+//go:build !linux
+
+package file
+
+import (
+	"os"
+	"time"
+)
+
+// The access time is not available portably, the modification time is used
+// instead.
+func accessTime(
+	info os.FileInfo,
+) time.Time {
+	return info.ModTime()
+}
+
+// Extended attributes are only copied on Linux.
+func copyExtendedAttributes(
+	sourcePath string,
+	destinationPath string,
+) error {
+	return nil
+}
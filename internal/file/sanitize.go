@@ -0,0 +1,225 @@
+package file
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const SEGMENT_LENGTH_MAX = 255
+
+var sanitizeProfiles = []string{
+	"posix",
+	"windows",
+	"exfat",
+	"strict-ascii",
+}
+
+var normalizations = []string{
+	"none",
+	"nfc",
+	"nfd",
+}
+
+// Names Windows reserves for devices, with or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Rewrites rendered paths so they are valid on the target file system. The
+// profile is one of posix, windows, exfat or strict-ascii. Characters that are
+// not allowed are swapped for the replacement, and the normalization is one of
+// none, nfc or nfd.
+type Sanitizer struct {
+	Profile       string
+	Replacement   string
+	Normalization string
+}
+
+// Returns the available sanitizer profiles.
+func GetSanitizeProfiles() []string {
+	return sanitizeProfiles
+}
+
+// Returns the available unicode normalizations.
+func GetNormalizations() []string {
+	return normalizations
+}
+
+// Creates a sanitizer after checking its settings. The replacement itself has
+// to be valid for the profile.
+func NewSanitizer(
+	profile string,
+	replacement string,
+	normalization string,
+) (
+	Sanitizer,
+	error,
+) {
+	if !slices.Contains(sanitizeProfiles, profile) {
+		return Sanitizer{}, fmt.Errorf("unknown sanitize profile %q, expected one of %s", profile, strings.Join(sanitizeProfiles, ", "))
+	}
+	if !slices.Contains(normalizations, normalization) {
+		return Sanitizer{}, fmt.Errorf("unknown normalization %q, expected one of %s", normalization, strings.Join(normalizations, ", "))
+	}
+
+	sanitizer := Sanitizer{
+		Profile:       profile,
+		Replacement:   replacement,
+		Normalization: normalization,
+	}
+	if replacement == "" || strings.ContainsFunc(replacement, sanitizer.isInvalid) {
+		return Sanitizer{}, fmt.Errorf("replacement %q is not allowed in %s file names", replacement, profile)
+	}
+	return sanitizer, nil
+}
+
+// Sanitizes every segment of a relative path. The extension of the last
+// segment is kept when it has to be shortened.
+func (
+	sanitizer Sanitizer,
+) Sanitize(
+	path string,
+) string {
+	if sanitizer.Profile == "" {
+		return path
+	}
+
+	segments := strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == filepath.Separator
+	})
+	segments = slices.DeleteFunc(segments, func(segment string) bool {
+		return segment == "."
+	})
+	for i, segment := range segments {
+		segments[i] = sanitizer.sanitizeSegment(segment, i == len(segments)-1)
+	}
+	return strings.Join(segments, "/")
+}
+
+func (
+	sanitizer Sanitizer,
+) sanitizeSegment(
+	segment string,
+	last bool,
+) string {
+	switch sanitizer.Normalization {
+	case "nfc":
+		segment = norm.NFC.String(segment)
+	case "nfd":
+		segment = norm.NFD.String(segment)
+	}
+
+	if sanitizer.Profile == "strict-ascii" {
+		// Decompose accented letters so only their base letter remains.
+		segment = strings.Map(func(r rune) rune {
+			if unicode.Is(unicode.Mn, r) {
+				return -1
+			}
+			return r
+		}, norm.NFD.String(segment))
+	}
+
+	var sanitized strings.Builder
+	for _, r := range segment {
+		if sanitizer.isInvalid(r) {
+			sanitized.WriteString(sanitizer.Replacement)
+		} else {
+			sanitized.WriteRune(r)
+		}
+	}
+	segment = sanitized.String()
+
+	if sanitizer.Profile != "posix" {
+		segment = strings.TrimRight(segment, ". ")
+
+		base, _, _ := strings.Cut(segment, ".")
+		if windowsReservedNames[strings.ToUpper(base)] {
+			segment = base + sanitizer.Replacement + segment[len(base):]
+		}
+	}
+
+	if segment == "" || segment == "." || segment == ".." {
+		segment = sanitizer.Replacement
+	}
+
+	return sanitizer.truncate(segment, last)
+}
+
+// Whether the character can not be used in a file name.
+func (
+	sanitizer Sanitizer,
+) isInvalid(
+	r rune,
+) bool {
+	if r == 0 || r == '/' {
+		return true
+	}
+
+	switch sanitizer.Profile {
+	case "windows", "exfat":
+		return r < 32 || strings.ContainsRune(`<>:"\|?*`, r)
+	case "strict-ascii":
+		return !(r >= 'a' && r <= 'z' ||
+			r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' ||
+			r == '.' || r == '_' || r == '-')
+	}
+	return false
+}
+
+// The length of a segment as counted by the file system, in bytes for posix
+// and in UTF-16 code units for Windows and exFAT.
+func (
+	sanitizer Sanitizer,
+) length(
+	segment string,
+) int {
+	switch sanitizer.Profile {
+	case "windows", "exfat":
+		return len(utf16.Encode([]rune(segment)))
+	}
+	return len(segment)
+}
+
+// Shortens the segment to the maximum length, keeping the extension of the
+// last segment.
+func (
+	sanitizer Sanitizer,
+) truncate(
+	segment string,
+	last bool,
+) string {
+	if sanitizer.length(segment) <= SEGMENT_LENGTH_MAX {
+		return segment
+	}
+
+	ext := ""
+	if last {
+		ext = filepath.Ext(segment)
+		if sanitizer.length(ext) >= SEGMENT_LENGTH_MAX/2 {
+			ext = ""
+		}
+	}
+
+	base := segment[:len(segment)-len(ext)]
+	for sanitizer.length(base+ext) > SEGMENT_LENGTH_MAX {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+
+	if sanitizer.Profile != "posix" {
+		base = strings.TrimRight(base, ". ")
+	}
+	return base + ext
+}
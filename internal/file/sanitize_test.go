@@ -0,0 +1,106 @@
+package file
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitize(
+	t *testing.T,
+) {
+	tests := []struct {
+		name     string
+		profile  string
+		path     string
+		expected string
+	}{
+		{name: "posix keeps names", profile: "posix", path: "CON/a:b?.txt", expected: "CON/a:b?.txt"},
+		{name: "posix dot segments", profile: "posix", path: "./a/../b", expected: "a/_/b"},
+		{name: "windows reserved name", profile: "windows", path: "CON", expected: "CON_"},
+		{name: "windows reserved name with extension", profile: "windows", path: "2024/aux.txt", expected: "2024/aux_.txt"},
+		{name: "windows reserved device number", profile: "windows", path: "LPT1.tar.gz", expected: "LPT1_.tar.gz"},
+		{name: "windows not reserved", profile: "windows", path: "CONSOLE.txt", expected: "CONSOLE.txt"},
+		{name: "windows invalid characters", profile: "windows", path: `a<b>c:d"e|f?g*h.txt`, expected: "a_b_c_d_e_f_g_h.txt"},
+		{name: "windows trailing dots and spaces", profile: "windows", path: "dir. . /name. ", expected: "dir/name"},
+		{name: "windows only dots", profile: "windows", path: ".../a.txt", expected: "_/a.txt"},
+		{name: "exfat control characters", profile: "exfat", path: "a\x01b.txt", expected: "a_b.txt"},
+		{name: "strict-ascii accents", profile: "strict-ascii", path: "Café Zürich/é.jpg", expected: "Cafe_Zurich/e.jpg"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sanitizer, err := NewSanitizer(test.profile, "_", "none")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sanitized := sanitizer.Sanitize(test.path); sanitized != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, sanitized)
+			}
+		})
+	}
+}
+
+func TestSanitizeTruncate(
+	t *testing.T,
+) {
+	tests := []struct {
+		profile string
+		name    string
+	}{
+		{profile: "posix", name: strings.Repeat("a", 300) + ".jpg"},
+		{profile: "posix", name: strings.Repeat("é", 200) + ".jpg"},
+		{profile: "windows", name: strings.Repeat("a", 300) + ".jpg"},
+		{profile: "windows", name: strings.Repeat("a", 253) + ". .jpg"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.profile, func(t *testing.T) {
+			sanitizer, err := NewSanitizer(test.profile, "_", "none")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sanitized := sanitizer.Sanitize(test.name)
+			if length := sanitizer.length(sanitized); length > SEGMENT_LENGTH_MAX {
+				t.Errorf("expected at most %d, got %d", SEGMENT_LENGTH_MAX, length)
+			}
+			if !strings.HasSuffix(sanitized, ".jpg") {
+				t.Errorf("expected the extension to be kept, got %q", sanitized)
+			}
+			if test.profile == "windows" && strings.HasSuffix(strings.TrimSuffix(sanitized, ".jpg"), ".") {
+				t.Errorf("expected no trailing dot before the extension, got %q", sanitized)
+			}
+		})
+	}
+}
+
+func TestNewSanitizer(
+	t *testing.T,
+) {
+	tests := []struct {
+		profile       string
+		replacement   string
+		normalization string
+		valid         bool
+	}{
+		{profile: "posix", replacement: "_", normalization: "none", valid: true},
+		{profile: "windows", replacement: "-", normalization: "nfc", valid: true},
+		{profile: "unknown", replacement: "_", normalization: "none"},
+		{profile: "posix", replacement: "_", normalization: "nfkc"},
+		{profile: "posix", replacement: "", normalization: "none"},
+		{profile: "posix", replacement: "/", normalization: "none"},
+		{profile: "windows", replacement: ":", normalization: "none"},
+		{profile: "strict-ascii", replacement: " ", normalization: "none"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.profile+" "+test.replacement, func(t *testing.T) {
+			_, err := NewSanitizer(test.profile, test.replacement, test.normalization)
+			if test.valid && err != nil {
+				t.Fatalf("expected the settings to be accepted: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected the settings to be rejected")
+			}
+		})
+	}
+}
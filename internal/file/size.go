@@ -0,0 +1,73 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+const SIZE_BUCKETS_DEFAULT = "small<1MB<medium<100MB<large"
+
+// Sizes in bytes that separate named buckets, for example
+// "small<1MB<medium<100MB<large".
+type sizeBuckets struct {
+	names      []string
+	thresholds []uint64
+}
+
+func parseSizeBuckets(
+	specification string,
+) (
+	sizeBuckets,
+	error,
+) {
+	if specification == "" {
+		specification = SIZE_BUCKETS_DEFAULT
+	}
+
+	parts := strings.Split(specification, "<")
+	if len(parts)%2 == 0 {
+		return sizeBuckets{}, fmt.Errorf("size buckets %q should alternate names and sizes", specification)
+	}
+
+	var buckets sizeBuckets
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if i%2 == 0 {
+			buckets.names = append(buckets.names, part)
+			continue
+		}
+
+		threshold, err := humanize.ParseBytes(part)
+		if err != nil {
+			return sizeBuckets{}, fmt.Errorf("invalid size %q in size buckets %q: %w", part, specification, err)
+		}
+		if len(buckets.thresholds) > 0 && threshold <= buckets.thresholds[len(buckets.thresholds)-1] {
+			return sizeBuckets{}, fmt.Errorf("sizes in size buckets %q should be increasing", specification)
+		}
+		buckets.thresholds = append(buckets.thresholds, threshold)
+	}
+	return buckets, nil
+}
+
+// Returns the name of the bucket the size falls into.
+func (
+	buckets sizeBuckets,
+) bucket(
+	size uint64,
+) string {
+	for i, threshold := range buckets.thresholds {
+		if size < threshold {
+			return buckets.names[i]
+		}
+	}
+	return buckets.names[len(buckets.names)-1]
+}
+
+// Formats the size in a short human readable form, for example "4.2MB".
+func formatSize(
+	size uint64,
+) string {
+	return strings.ReplaceAll(humanize.Bytes(size), " ", "")
+}
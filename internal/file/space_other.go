@@ -0,0 +1,27 @@
+//go:build !unix && !windows
+
+package file
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Free space can not be read on this platform.
+func FreeSpace(
+	path string,
+) (
+	uint64,
+	error,
+) {
+	return 0, fmt.Errorf("failed to read free space of %s: %w", path, errors.ErrUnsupported)
+}
+
+// Without device information every path is assumed to be on another file
+// system.
+func SameDevice(
+	a string,
+	b string,
+) bool {
+	return false
+}
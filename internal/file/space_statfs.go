@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd || dragonfly || aix
+
+package file
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns the number of bytes available to unprivileged users on the file
+// system of the path.
+func FreeSpace(
+	path string,
+) (
+	uint64,
+	error,
+) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to read free space of %s: %w", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,24 @@
+//go:build netbsd || solaris
+
+package file
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns the number of bytes available to unprivileged users on the file
+// system of the path. These systems only report it through statvfs.
+func FreeSpace(
+	path string,
+) (
+	uint64,
+	error,
+) {
+	var stat unix.Statvfs_t
+	if err := unix.Statvfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to read free space of %s: %w", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Frsize), nil
+}
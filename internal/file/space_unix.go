@@ -0,0 +1,27 @@
+//go:build unix
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+// Reports whether both paths are on the same file system, so a file can be
+// renamed from one to the other.
+func SameDevice(
+	a string,
+	b string,
+) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	aStat, aOk := aInfo.Sys().(*syscall.Stat_t)
+	bStat, bOk := bInfo.Sys().(*syscall.Stat_t)
+	return aOk && bOk && aStat.Dev == bStat.Dev
+}
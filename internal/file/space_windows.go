@@ -0,0 +1,47 @@
+//go:build windows
+
+package file
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// Returns the number of bytes available to the user on the volume of the
+// path.
+func FreeSpace(
+	path string,
+) (
+	uint64,
+	error,
+) {
+	pathPointer, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read free space of %s: %w", path, err)
+	}
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPointer, &available, nil, nil); err != nil {
+		return 0, fmt.Errorf("failed to read free space of %s: %w", path, err)
+	}
+	return available, nil
+}
+
+// Reports whether both paths are on the same volume, so a file can be renamed
+// from one to the other.
+func SameDevice(
+	a string,
+	b string,
+) bool {
+	aPath, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	bPath, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(filepath.VolumeName(aPath), filepath.VolumeName(bPath))
+}
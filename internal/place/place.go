@@ -0,0 +1,231 @@
+package place
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+const EARTH_RADIUS = 6371008.8
+
+// A named area, either a circle around a point or a set of polygons.
+type Place struct {
+	Name     string
+	Priority int
+
+	// Center of a circle as longitude and latitude, with its radius in meters.
+	Center [2]float64
+	Radius float64
+
+	// Polygons made up of rings of longitude and latitude points. The first
+	// ring of a polygon is its outline, any following rings are holes.
+	Polygons [][][][2]float64
+}
+
+type Places []Place
+
+type geoJson struct {
+	Type       string          `json:"type"`
+	Features   []geoJson       `json:"features"`
+	Geometry   *geoJson        `json:"geometry"`
+	Properties json.RawMessage `json:"properties"`
+
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type geoJsonProperties struct {
+	Name     string  `json:"name"`
+	Priority int     `json:"priority"`
+	Radius   float64 `json:"radius"`
+}
+
+// Reads the places from a GeoJSON file. Every feature needs a name property.
+// Points need a radius property in meters and are treated as circles, polygons
+// and multi polygons are used as is. Overlapping places are resolved by the
+// priority property, the highest wins.
+func LoadPlaces(
+	path string,
+) (
+	Places,
+	error,
+) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read places file: %w", err)
+	}
+
+	var document geoJson
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse places file: %w", err)
+	}
+
+	var features []geoJson
+	switch document.Type {
+	case "FeatureCollection":
+		features = document.Features
+	case "Feature":
+		features = []geoJson{document}
+	default:
+		return nil, fmt.Errorf("unsupported GeoJSON type %q, expected a feature or feature collection", document.Type)
+	}
+
+	places := make(Places, 0, len(features))
+	for i, feature := range features {
+		place, err := parseFeature(feature)
+		if err != nil {
+			return nil, fmt.Errorf("invalid place at feature %d: %w", i, err)
+		}
+		places = append(places, place)
+	}
+	return places, nil
+}
+
+func parseFeature(
+	feature geoJson,
+) (
+	Place,
+	error,
+) {
+	var properties geoJsonProperties
+	if len(feature.Properties) > 0 {
+		if err := json.Unmarshal(feature.Properties, &properties); err != nil {
+			return Place{}, err
+		}
+	}
+	if properties.Name == "" {
+		return Place{}, fmt.Errorf("missing name property")
+	}
+	if feature.Geometry == nil {
+		return Place{}, fmt.Errorf("missing geometry")
+	}
+
+	place := Place{
+		Name:     properties.Name,
+		Priority: properties.Priority,
+	}
+
+	geometry := feature.Geometry
+	switch geometry.Type {
+	case "Point":
+		if properties.Radius <= 0 {
+			return Place{}, fmt.Errorf("point %q requires a positive radius property", place.Name)
+		}
+		if err := json.Unmarshal(geometry.Coordinates, &place.Center); err != nil {
+			return Place{}, err
+		}
+		place.Radius = properties.Radius
+
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygon); err != nil {
+			return Place{}, err
+		}
+		place.Polygons = [][][][2]float64{polygon}
+
+	case "MultiPolygon":
+		if err := json.Unmarshal(geometry.Coordinates, &place.Polygons); err != nil {
+			return Place{}, err
+		}
+
+	default:
+		return Place{}, fmt.Errorf("unsupported geometry type %q", geometry.Type)
+	}
+
+	return place, nil
+}
+
+// Returns the name of the place containing the location. When multiple places
+// contain it the one with the highest priority wins, ties go to the place
+// listed first.
+func (
+	places Places,
+) Match(
+	latitude float64,
+	longitude float64,
+) (
+	string,
+	bool,
+) {
+	var match *Place
+	for i := range places {
+		place := &places[i]
+		if !place.Contains(latitude, longitude) {
+			continue
+		}
+		if match == nil || place.Priority > match.Priority {
+			match = place
+		}
+	}
+
+	if match == nil {
+		return "", false
+	}
+	return match.Name, true
+}
+
+// Whether the location falls inside the place.
+func (
+	place *Place,
+) Contains(
+	latitude float64,
+	longitude float64,
+) bool {
+	if place.Radius > 0 {
+		return distance(latitude, longitude, place.Center[1], place.Center[0]) <= place.Radius
+	}
+
+	for _, polygon := range place.Polygons {
+		if len(polygon) == 0 || !ringContains(polygon[0], latitude, longitude) {
+			continue
+		}
+
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if ringContains(hole, latitude, longitude) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// Ray casting test of whether a point lies inside a ring.
+func ringContains(
+	ring [][2]float64,
+	latitude float64,
+	longitude float64,
+) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+
+		if (yi > latitude) != (yj > latitude) &&
+			longitude < (xj-xi)*(latitude-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Great-circle distance in meters between two locations.
+func distance(
+	latitudeA float64,
+	longitudeA float64,
+	latitudeB float64,
+	longitudeB float64,
+) float64 {
+	phiA := latitudeA * math.Pi / 180
+	phiB := latitudeB * math.Pi / 180
+	deltaPhi := (latitudeB - latitudeA) * math.Pi / 180
+	deltaLambda := (longitudeB - longitudeA) * math.Pi / 180
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
+		math.Cos(phiA)*math.Cos(phiB)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+	return 2 * EARTH_RADIUS * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,143 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// What happens when a destination already exists, or is already used by an
+// earlier file of the same run.
+const (
+	COLLISION_RENAME            = "rename"
+	COLLISION_SKIP              = "skip"
+	COLLISION_SKIP_IF_IDENTICAL = "skip-if-identical"
+	COLLISION_KEEP_NEWER        = "keep-newer"
+	COLLISION_OVERWRITE         = "overwrite"
+	COLLISION_FAIL              = "fail"
+)
+
+var collisionPolicies = []string{
+	COLLISION_RENAME,
+	COLLISION_SKIP,
+	COLLISION_SKIP_IF_IDENTICAL,
+	COLLISION_KEEP_NEWER,
+	COLLISION_OVERWRITE,
+	COLLISION_FAIL,
+}
+
+// Returns the available collision policies, the first is the default.
+func GetCollisionPolicies() []string {
+	return collisionPolicies
+}
+
+// Checks whether the value is a known collision policy.
+func ParseCollisionPolicy(
+	value string,
+) (
+	string,
+	error,
+) {
+	if !slices.Contains(collisionPolicies, value) {
+		return "", fmt.Errorf("unknown collision policy %q, expected one of %s", value, strings.Join(collisionPolicies, ", "))
+	}
+	return value, nil
+}
+
+// Returns the file the destination collides with, either the existing
+// destination or the source of an earlier file claiming it during this run.
+func (
+	run *Run,
+) collidingFile(
+	destinationPath string,
+) (
+	string,
+	bool,
+) {
+	if _, err := os.Lstat(destinationPath); err == nil {
+		return destinationPath, true
+	}
+	if source, ok := run.claimed[destinationPath]; ok {
+		return source, true
+	}
+	return "", false
+}
+
+// Applies the collision policy to the destination of the item. The item's
+// destination is changed when the policy renames it.
+func (
+	run *Run,
+) resolveCollision(
+	item *Item,
+) (
+	string,
+	error,
+) {
+	existing, ok := run.collidingFile(item.DestinationPath)
+	if !ok {
+		return OUTCOME_TRANSFERRED, nil
+	}
+
+	policy := run.options.Collision
+	if policy == "" {
+		policy = COLLISION_RENAME
+	}
+
+	switch policy {
+	case COLLISION_FAIL:
+		return "", fmt.Errorf("destination %s already exists", item.DestinationPath)
+
+	case COLLISION_SKIP:
+		return OUTCOME_SKIPPED, nil
+
+	case COLLISION_OVERWRITE:
+		return OUTCOME_OVERWRITTEN, nil
+
+	case COLLISION_SKIP_IF_IDENTICAL:
+		identical, err := file.IdenticalFiles(item.Path, existing)
+		if err != nil {
+			return "", fmt.Errorf("failed to compare %s with %s: %w", item.Path, existing, err)
+		}
+		if identical {
+			return OUTCOME_SKIPPED, nil
+		}
+
+	case COLLISION_KEEP_NEWER:
+		sourceInfo, err := os.Stat(item.Path)
+		if err != nil {
+			return "", err
+		}
+		existingInfo, err := os.Stat(existing)
+		if err != nil {
+			return "", err
+		}
+		if sourceInfo.ModTime().After(existingInfo.ModTime()) {
+			return OUTCOME_OVERWRITTEN, nil
+		}
+		return OUTCOME_SKIPPED, nil
+	}
+
+	item.DestinationPath = run.freePath(item.DestinationPath)
+	return OUTCOME_RENAMED, nil
+}
+
+// Returns the first path with a -1, -2, ... suffix before the extension that
+// is neither on disk nor claimed during this run.
+func (
+	run *Run,
+) freePath(
+	destinationPath string,
+) string {
+	ext := filepath.Ext(destinationPath)
+	stem := strings.TrimSuffix(destinationPath, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", stem, i, ext)
+		if _, ok := run.collidingFile(candidate); !ok {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package sorter
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// What happens to files with the same contents as a file found earlier, or
+// as a file already in the destination.
+const (
+	DUPLICATES_KEEP = "keep"
+	DUPLICATES_SKIP = "skip"
+	DUPLICATES_LINK = "link"
+	DUPLICATES_MOVE = "move"
+)
+
+var duplicatePolicies = []string{
+	DUPLICATES_KEEP,
+	DUPLICATES_SKIP,
+	DUPLICATES_LINK,
+	DUPLICATES_MOVE,
+}
+
+// Directory in the destination duplicates are put in by the move policy.
+const DUPLICATES_DIRECTORY = "duplicates"
+
+// Number of bytes hashed to tell files of the same size apart before hashing
+// them completely.
+const PARTIAL_HASH_SIZE = 64 << 10
+
+// Returns the available duplicate policies, the first is the default.
+func GetDuplicatePolicies() []string {
+	return duplicatePolicies
+}
+
+// Checks whether the value is a known duplicate policy.
+func ParseDuplicatePolicy(
+	value string,
+) (
+	string,
+	error,
+) {
+	if !slices.Contains(duplicatePolicies, value) {
+		return "", fmt.Errorf("unknown duplicate policy %q, expected one of %s", value, strings.Join(duplicatePolicies, ", "))
+	}
+	return value, nil
+}
+
+// Files with the same contents. The original is the file kept, a file in the
+// destination when there is one, otherwise the first file of the plan.
+type DuplicateGroup struct {
+	Hash       string
+	Size       int64
+	Original   string
+	Duplicates []string
+}
+
+// A file taking part in the duplicate detection.
+type duplicateCandidate struct {
+	path          string
+	size          int64
+	inDestination bool
+}
+
+// Finds the planned files with the same contents as each other or as a file
+// in the destination. Files are only hashed when another file has the same
+// size, and completely only when the start of their contents matches too.
+func FindDuplicates(
+	items []Item,
+	options Options,
+) (
+	[]DuplicateGroup,
+	error,
+) {
+	var candidates []duplicateCandidate
+	err := filepath.WalkDir(
+		options.DestDir,
+		func(
+			path string,
+			entry fs.DirEntry,
+			err error,
+		) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if entry.Name() == JOURNAL_DIRECTORY {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			candidates = append(candidates, duplicateCandidate{
+				path:          path,
+				size:          info.Size(),
+				inDestination: true,
+			})
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to index destination: %w", err)
+	}
+	for _, item := range items {
+		candidates = append(candidates, duplicateCandidate{
+			path: item.Path,
+			size: item.Size,
+		})
+	}
+
+	bySize := map[int64][]duplicateCandidate{}
+	for _, candidate := range candidates {
+		bySize[candidate.size] = append(bySize[candidate.size], candidate)
+	}
+
+	duplicatesDir := filepath.Join(options.DestDir, DUPLICATES_DIRECTORY)
+	var groups []DuplicateGroup
+	for size, sameSize := range bySize {
+		if !hasPlannedPair(sameSize) {
+			continue
+		}
+
+		byStart, err := groupByHash(sameSize, func(path string) (string, error) {
+			return file.HashFileStart(path, PARTIAL_HASH_SIZE)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for start, sameStart := range byStart {
+			if !hasPlannedPair(sameStart) {
+				continue
+			}
+
+			byContents := map[string][]duplicateCandidate{start: sameStart}
+			if size > PARTIAL_HASH_SIZE {
+				byContents, err = groupByHash(sameStart, func(path string) (string, error) {
+					digests, err := file.HashFile(path, []string{file.HASH_DEFAULT})
+					return digests[file.HASH_DEFAULT], err
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			for hash, sameContents := range byContents {
+				if hasPlannedPair(sameContents) {
+					groups = append(groups, newDuplicateGroup(hash, size, sameContents, duplicatesDir))
+				}
+			}
+		}
+	}
+
+	slices.SortFunc(groups, func(a DuplicateGroup, b DuplicateGroup) int {
+		return strings.Compare(a.Original, b.Original)
+	})
+	return groups, nil
+}
+
+// Whether there are multiple files of which at least one is planned, pairs
+// of files only in the destination are left alone.
+func hasPlannedPair(
+	candidates []duplicateCandidate,
+) bool {
+	return len(candidates) > 1 && slices.ContainsFunc(candidates, func(candidate duplicateCandidate) bool {
+		return !candidate.inDestination
+	})
+}
+
+func groupByHash(
+	candidates []duplicateCandidate,
+	hash func(path string) (string, error),
+) (
+	map[string][]duplicateCandidate,
+	error,
+) {
+	groups := map[string][]duplicateCandidate{}
+	for _, candidate := range candidates {
+		digest, err := hash(candidate.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", candidate.path, err)
+		}
+		groups[digest] = append(groups[digest], candidate)
+	}
+	return groups, nil
+}
+
+// Picks the original, preferring files in the destination outside of the
+// duplicates directory, then the first planned file.
+func newDuplicateGroup(
+	hash string,
+	size int64,
+	candidates []duplicateCandidate,
+	duplicatesDir string,
+) DuplicateGroup {
+	rank := func(candidate duplicateCandidate) int {
+		switch {
+		case candidate.inDestination && !isWithin(candidate.path, duplicatesDir):
+			return 0
+		case candidate.inDestination:
+			return 1
+		}
+		return 2
+	}
+	original := 0
+	for i, candidate := range candidates {
+		if rank(candidate) < rank(candidates[original]) {
+			original = i
+		}
+	}
+
+	group := DuplicateGroup{
+		Hash:     hash,
+		Size:     size,
+		Original: candidates[original].path,
+	}
+	for i, candidate := range candidates {
+		if i != original && !candidate.inDestination {
+			group.Duplicates = append(group.Duplicates, candidate.path)
+		}
+	}
+	return group
+}
+
+// Writes every group as its original followed by its duplicates.
+func WriteDuplicateReport(
+	writer io.Writer,
+	groups []DuplicateGroup,
+) error {
+	for _, group := range groups {
+		_, err := fmt.Fprintf(writer, "%s (%s, %s %s)\n", group.Original, humanize.Bytes(uint64(group.Size)), file.HASH_DEFAULT, group.Hash)
+		if err != nil {
+			return err
+		}
+		for _, duplicate := range group.Duplicates {
+			if _, err := fmt.Fprintf(writer, "  %s\n", duplicate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package sorter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// File in the source tree listing paths to leave out, in gitignore syntax.
+const IGNORE_FILE = ".sorterignore"
+
+// A glob pattern of paths relative to the source directory, written like a
+// gitignore pattern. * and ? do not match a slash while ** matches any number
+// of directories. Patterns without a slash match the name at any depth, and a
+// trailing slash only matches directories.
+type PathPattern struct {
+	Pattern string
+
+	expression    *regexp.Regexp
+	anchored      bool
+	directoryOnly bool
+}
+
+// A pattern read from an ignore file, relative to the directory of the file.
+type ignoreRule struct {
+	PathPattern
+	base   string
+	negate bool
+}
+
+// Parses a comma separated list of patterns.
+func ParsePathPatterns(
+	value string,
+) (
+	[]PathPattern,
+	error,
+) {
+	var patterns []PathPattern
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pattern, err := ParsePathPattern(part)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func ParsePathPattern(
+	value string,
+) (
+	PathPattern,
+	error,
+) {
+	pattern := PathPattern{
+		Pattern: value,
+	}
+	if strings.HasSuffix(value, "/") {
+		pattern.directoryOnly = true
+		value = strings.TrimRight(value, "/")
+	}
+	pattern.anchored = strings.Contains(value, "/")
+	value = strings.TrimPrefix(value, "/")
+	if value == "" {
+		return PathPattern{}, fmt.Errorf("invalid pattern %q, it matches nothing", pattern.Pattern)
+	}
+
+	var err error
+	pattern.expression, err = globExpression(value)
+	if err != nil {
+		return PathPattern{}, fmt.Errorf("invalid pattern %q: %w", pattern.Pattern, err)
+	}
+	return pattern, nil
+}
+
+// Reports whether the slash separated path matches the pattern.
+func (
+	pattern PathPattern,
+) Match(
+	relative string,
+	directory bool,
+) bool {
+	if pattern.directoryOnly && !directory {
+		return false
+	}
+	if !pattern.anchored {
+		relative = path.Base(relative)
+	}
+	return pattern.expression.MatchString(relative)
+}
+
+func (
+	pattern PathPattern,
+) String() string {
+	return pattern.Pattern
+}
+
+// Translates a glob to a regular expression matching the whole path.
+func globExpression(
+	glob string,
+) (
+	*regexp.Regexp,
+	error,
+) {
+	var expression strings.Builder
+	expression.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch character := glob[i]; character {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					expression.WriteString("(?:.*/)?")
+				} else {
+					expression.WriteString(".*")
+				}
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		case '[':
+			end := -1
+			if i+2 < len(glob) {
+				end = strings.IndexByte(glob[i+2:], ']')
+			}
+			if end < 0 {
+				return nil, errors.New("unterminated character class")
+			}
+			class := glob[i+1 : i+2+end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			expression.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += 2 + end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			expression.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(character)))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.Compile(expression.String())
+}
+
+// Reads the rules of an ignore file, returning none when there is no file.
+// Blank lines and lines starting with # are skipped, rules starting with !
+// include paths excluded by earlier rules again.
+func readIgnoreFile(
+	directory string,
+	base string,
+) (
+	[]ignoreRule,
+	error,
+) {
+	ignoreFile, err := os.Open(filepath.Join(directory, IGNORE_FILE))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ignore file: %w", err)
+	}
+	defer ignoreFile.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(ignoreFile)
+	for line := 1; scanner.Scan(); line++ {
+		value := strings.TrimRight(scanner.Text(), " \t\r")
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+
+		rule := ignoreRule{
+			base: base,
+		}
+		if strings.HasPrefix(value, "!") {
+			rule.negate = true
+			value = value[1:]
+		} else if strings.HasPrefix(value, `\!`) || strings.HasPrefix(value, `\#`) {
+			value = value[1:]
+		}
+		rule.PathPattern, err = ParsePathPattern(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", filepath.Join(directory, IGNORE_FILE), line, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ignore file: %w", err)
+	}
+	return rules, nil
+}
+
+// Decides which paths of the source directory are walked, using the ignore
+// files found along the way and the include and exclude patterns.
+type pathFilter struct {
+	options Options
+	// Rules of the ignore files that apply within each walked directory.
+	rules map[string][]ignoreRule
+}
+
+func newPathFilter(
+	options Options,
+) *pathFilter {
+	return &pathFilter{
+		options: options,
+		rules:   map[string][]ignoreRule{},
+	}
+}
+
+// Reports whether the walked path is left out. Left out directories return
+// filepath.SkipDir so their contents are not walked.
+func (
+	filter *pathFilter,
+) skip(
+	walked string,
+	info fs.FileInfo,
+) (
+	bool,
+	error,
+) {
+	walked = filepath.Clean(walked)
+	relative, err := filepath.Rel(filter.options.SourceDir, walked)
+	if err != nil {
+		return true, err
+	}
+	relative = filepath.ToSlash(relative)
+
+	if relative != "." {
+		if !info.IsDir() && info.Name() == IGNORE_FILE {
+			return true, nil
+		}
+		if filter.ignored(relative, filter.rules[filepath.Dir(walked)], info.IsDir()) {
+			if info.IsDir() {
+				return true, filepath.SkipDir
+			}
+			return true, nil
+		}
+	}
+
+	if info.IsDir() {
+		base := relative
+		if base == "." {
+			base = ""
+		}
+		rules, err := readIgnoreFile(walked, base)
+		if err != nil {
+			return true, err
+		}
+		filter.rules[walked] = append(slices.Clip(filter.rules[filepath.Dir(walked)]), rules...)
+	}
+	return false, nil
+}
+
+// Applies the ignore rules in order, the last matching rule wins, followed by
+// the include and exclude patterns.
+func (
+	filter *pathFilter,
+) ignored(
+	relative string,
+	rules []ignoreRule,
+	directory bool,
+) bool {
+	ignored := false
+	for _, rule := range rules {
+		within := relative
+		if rule.base != "" {
+			within = strings.TrimPrefix(relative, rule.base+"/")
+		}
+		if rule.Match(within, directory) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored || filteredByPatterns(filter.options, relative, directory)
+}
+
+// Reports whether the path is left out by the exclude patterns or, for
+// files, by not matching any of the include patterns.
+func filteredByPatterns(
+	options Options,
+	relative string,
+	directory bool,
+) bool {
+	for _, pattern := range options.Exclude {
+		if pattern.Match(relative, directory) {
+			return true
+		}
+	}
+	if directory || len(options.Include) == 0 {
+		return false
+	}
+	for _, pattern := range options.Include {
+		if pattern.Match(relative, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// Applies the include and exclude patterns to items planned without them,
+// numbering the remaining items again.
+func FilterItems(
+	items []Item,
+	options Options,
+) []Item {
+	var filtered []Item
+	for _, item := range items {
+		relative, err := filepath.Rel(options.SourceDir, item.Path)
+		if err != nil {
+			continue
+		}
+		relative = filepath.ToSlash(relative)
+
+		excluded := filteredByPatterns(options, relative, false)
+		for directory := path.Dir(relative); !excluded && directory != "."; directory = path.Dir(directory) {
+			excluded = filteredByPatterns(options, directory, true)
+		}
+		if excluded {
+			continue
+		}
+
+		item.Index = len(filtered) + 1
+		filtered = append(filtered, item)
+	}
+	return filtered
+}
@@ -0,0 +1,121 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathPatternMatch(
+	t *testing.T,
+) {
+	tests := []struct {
+		pattern   string
+		path      string
+		directory bool
+		matches   bool
+	}{
+		{pattern: "*.jpg", path: "photo.jpg", matches: true},
+		{pattern: "*.jpg", path: "2024/03/photo.jpg", matches: true},
+		{pattern: "*.jpg", path: "photo.jpeg"},
+		{pattern: "photo?.jpg", path: "a/photo1.jpg", matches: true},
+		{pattern: "photo?.jpg", path: "photo10.jpg"},
+		{pattern: "/build", path: "build", directory: true, matches: true},
+		{pattern: "/build", path: "src/build", directory: true},
+		{pattern: "docs/*.md", path: "docs/readme.md", matches: true},
+		{pattern: "docs/*.md", path: "docs/api/readme.md"},
+		{pattern: "docs/*.md", path: "other/docs/readme.md"},
+		{pattern: "**/tmp", path: "tmp", directory: true, matches: true},
+		{pattern: "**/tmp", path: "a/b/tmp", directory: true, matches: true},
+		{pattern: "a/**/b", path: "a/b", matches: true},
+		{pattern: "a/**/b", path: "a/x/y/b", matches: true},
+		{pattern: "a/**/b", path: "c/a/x/b"},
+		{pattern: "cache/", path: "x/cache", directory: true, matches: true},
+		{pattern: "cache/", path: "x/cache"},
+		{pattern: "[!a]*.txt", path: "b.txt", matches: true},
+		{pattern: "[!a]*.txt", path: "a.txt"},
+		{pattern: "[0-9].txt", path: "7.txt", matches: true},
+		{pattern: `\*.txt`, path: "*.txt", matches: true},
+		{pattern: `\*.txt`, path: "a.txt"},
+		{pattern: "a.b", path: "axb"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			pattern, err := ParsePathPattern(test.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matches := pattern.Match(test.path, test.directory); matches != test.matches {
+				t.Errorf("expected %v, got %v", test.matches, matches)
+			}
+		})
+	}
+}
+
+func TestParsePathPatternInvalid(
+	t *testing.T,
+) {
+	for _, value := range []string{"/", "[abc", "a/[", "["} {
+		t.Run(value, func(t *testing.T) {
+			if _, err := ParsePathPattern(value); err == nil {
+				t.Error("expected the pattern to be rejected")
+			}
+		})
+	}
+}
+
+func TestIgnoreFile(
+	t *testing.T,
+) {
+	sourceDir := t.TempDir()
+	for path, contents := range map[string]string{
+		IGNORE_FILE:                       "# logs\n*.log\n!keep.log\n/raw/\n\\!important.txt\n",
+		filepath.Join("sub", IGNORE_FILE): "!sub.log\nlocal.txt\n",
+	} {
+		path = filepath.Join(sourceDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter := newPathFilter(Options{SourceDir: sourceDir})
+	for _, directory := range []string{sourceDir, filepath.Join(sourceDir, "sub")} {
+		info, err := os.Stat(directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := filter.skip(directory, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path      string
+		directory bool
+		ignored   bool
+	}{
+		{path: "photo.jpg"},
+		{path: "debug.log", ignored: true},
+		{path: "keep.log"},
+		{path: "raw", directory: true, ignored: true},
+		{path: "sub/raw", directory: true},
+		{path: "!important.txt", ignored: true},
+		{path: "sub/debug.log", ignored: true},
+		{path: "sub/sub.log"},
+		{path: "sub/local.txt", ignored: true},
+		{path: "local.txt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			directory := filepath.Dir(filepath.Join(sourceDir, test.path))
+			if ignored := filter.ignored(test.path, filter.rules[directory], test.directory); ignored != test.ignored {
+				t.Errorf("expected %v, got %v", test.ignored, ignored)
+			}
+		})
+	}
+}
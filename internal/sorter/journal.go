@@ -0,0 +1,276 @@
+package sorter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// Directory inside the destination the journals are written to.
+const JOURNAL_DIRECTORY = ".file_sorter"
+
+// Layout of the time in journal file names.
+const JOURNAL_TIME_LAYOUT = "20060102-150405"
+
+// Action of the first entry of a journal, listing the directories created for
+// the destination and the journal itself.
+const ACTION_CREATE_DIRECTORIES = "create-directories"
+
+// A single operation of a run, written as one line of JSON.
+type JournalEntry struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// File the destination was linked to instead, for duplicates.
+	Original string `json:"original,omitempty"`
+	// Transfer mode used, such as copy or move.
+	Action  string `json:"action"`
+	Outcome string `json:"outcome"`
+	// Digest of the copied contents, see file.HASH_DEFAULT, and the size of
+	// the file.
+	Hash        string `json:"hash,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	CrossDevice bool   `json:"cross_device,omitempty"`
+	// Directories created for the destination, outermost first.
+	Directories []string  `json:"directories,omitempty"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	// Mode and ownership of a moved file before the permissions were set,
+	// restored when the move is undone.
+	Restore *file.Permissions `json:"restore,omitempty"`
+	// Written right before the operation, followed by the same entry without
+	// it once the operation is done.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// Records the operations of a run so they can be undone.
+type Journal struct {
+	Path string
+
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Creates a new journal in the destination directory and writes the
+// operations of the run to it.
+func (
+	run *Run,
+) startJournal() (
+	string,
+	error,
+) {
+	directory := filepath.Join(run.options.DestDir, JOURNAL_DIRECTORY)
+	created, err := CreateDirectories(directory, run.options.Permissions)
+	if err != nil {
+		return "", fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	// The directory can be left by a run with other permissions.
+	if len(created) == 0 {
+		if err := run.options.Permissions.Apply(directory, true); err != nil {
+			return "", err
+		}
+	}
+
+	// Runs started within the same second get a numbered suffix.
+	name := "journal-" + time.Now().Format(JOURNAL_TIME_LAYOUT)
+	path := filepath.Join(directory, name+".jsonl")
+	journalFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, file.FILE_MODE)
+	for i := 1; errors.Is(err, os.ErrExist); i++ {
+		path = filepath.Join(directory, fmt.Sprintf("%s-%d.jsonl", name, i))
+		journalFile, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, file.FILE_MODE)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create journal: %w", err)
+	}
+	if err := run.options.Permissions.Apply(path, false); err != nil {
+		journalFile.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	run.journal = &Journal{
+		Path:    path,
+		file:    journalFile,
+		encoder: json.NewEncoder(journalFile),
+	}
+
+	if created = append(slices.Clone(run.created), created...); len(created) > 0 {
+		now := time.Now()
+		err := run.journal.Write(JournalEntry{
+			Action:      ACTION_CREATE_DIRECTORIES,
+			Directories: created,
+			Started:     now,
+			Finished:    now,
+		})
+		if err != nil {
+			run.Close()
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// Removes the journal once its run is undone, together with the state of the
+// run when it was interrupted.
+func RemoveJournal(
+	path string,
+) error {
+	statePath := filepath.Join(filepath.Dir(path), STATE_FILE)
+	if contents, err := os.ReadFile(statePath); err == nil {
+		var state State
+		if json.Unmarshal(contents, &state) == nil && filepath.Clean(state.Journal) == filepath.Clean(path) {
+			if err := os.Remove(statePath); err != nil {
+				return fmt.Errorf("failed to remove run state: %w", err)
+			}
+		}
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// Continues writing to an existing journal.
+func (
+	run *Run,
+) resumeJournal(
+	path string,
+) error {
+	journalFile, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, file.FILE_MODE)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	run.journal = &Journal{
+		Path:    path,
+		file:    journalFile,
+		encoder: json.NewEncoder(journalFile),
+	}
+	return nil
+}
+
+// Closes the journal of the run, if any. The run state is kept so the run can
+// be resumed.
+func (
+	run *Run,
+) Close() error {
+	if run.journal == nil {
+		return nil
+	}
+	err := run.journal.file.Close()
+	run.journal = nil
+	return err
+}
+
+// Appends the entry and syncs it, so the journal is complete up to the last
+// finished operation when the process is interrupted.
+func (
+	journal *Journal,
+) Write(
+	entry JournalEntry,
+) error {
+	if err := journal.encoder.Encode(entry); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := journal.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// Reads the entries of a journal in the order they were written. Pending
+// entries are left out once they are followed by their finished entry, the
+// ones left belong to operations interrupted before they were recorded, see
+// SettleEntry.
+func ReadJournal(
+	path string,
+) (
+	[]JournalEntry,
+	error,
+) {
+	journalFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer journalFile.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(journalFile)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid journal entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	finished := map[[2]string]bool{}
+	for _, entry := range entries {
+		if !entry.Pending {
+			finished[[2]string{entry.Source, entry.Destination}] = true
+		}
+	}
+	return slices.DeleteFunc(entries, func(entry JournalEntry) bool {
+		return entry.Pending && finished[[2]string{entry.Source, entry.Destination}]
+	}), nil
+}
+
+// Determines from the files in place whether the operation of a pending entry
+// took effect before the run was interrupted, returning it as a finished
+// entry. A move interrupted after copying the file to another file system
+// left the source in place and is returned as a copy.
+func SettleEntry(
+	entry JournalEntry,
+) (
+	JournalEntry,
+	bool,
+) {
+	info, err := os.Lstat(entry.Destination)
+	if err != nil {
+		return entry, false
+	}
+	entry.Pending = false
+
+	switch entry.Action {
+	case TRANSFER_HARDLINK:
+		target := entry.Source
+		if entry.Original != "" {
+			target = entry.Original
+		}
+		targetInfo, err := os.Stat(target)
+		return entry, err == nil && os.SameFile(info, targetInfo)
+
+	case TRANSFER_SYMLINK, TRANSFER_SYMLINK_RELATIVE:
+		return entry, info.Mode()&os.ModeSymlink != 0
+
+	case TRANSFER_MOVE:
+		if _, err := os.Lstat(entry.Source); errors.Is(err, os.ErrNotExist) {
+			return entry, true
+		}
+		entry.Action = TRANSFER_COPY
+	}
+
+	identical, err := file.IdenticalFiles(entry.Source, entry.Destination)
+	if err != nil || !identical {
+		return entry, false
+	}
+	digests, err := file.HashFile(entry.Destination, []string{file.HASH_DEFAULT})
+	if err != nil {
+		return entry, false
+	}
+	entry.Hash = digests[file.HASH_DEFAULT]
+	return entry, true
+}
@@ -0,0 +1,192 @@
+package sorter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// Layouts accepted by the date range, from the most to the least precise.
+var dateLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// Keeps the files whose probed metadata matches, all other files are counted
+// as filtered. Unset criteria match every file.
+type MetadataFilter struct {
+	// Categories, mime-type globs or extensions, see file.ParseTypePattern.
+	Types []string
+	// Size range in bytes, unbounded when zero.
+	MinSize uint64
+	MaxSize uint64
+	// Creation date range, the end is exclusive.
+	Since time.Time
+	Until time.Time
+	// Texts either the camera make or model contains, ignoring case.
+	Cameras []string
+}
+
+// Parses the values of the filter options, leaving the criteria of empty
+// values unset. Dates are given as 2024, 2024-03, 2024-03-15 or
+// 2024-03-15T10:30:00, the until date includes the whole period.
+func ParseMetadataFilter(
+	types string,
+	minSize string,
+	maxSize string,
+	since string,
+	until string,
+	cameras string,
+) (
+	MetadataFilter,
+	error,
+) {
+	var filter MetadataFilter
+	for _, value := range splitList(types) {
+		pattern, err := file.ParseTypePattern(value)
+		if err != nil {
+			return MetadataFilter{}, err
+		}
+		filter.Types = append(filter.Types, pattern)
+	}
+
+	var err error
+	if minSize != "" {
+		filter.MinSize, err = humanize.ParseBytes(minSize)
+		if err != nil {
+			return MetadataFilter{}, fmt.Errorf("invalid minimum size %q: %w", minSize, err)
+		}
+	}
+	if maxSize != "" {
+		filter.MaxSize, err = humanize.ParseBytes(maxSize)
+		if err != nil {
+			return MetadataFilter{}, fmt.Errorf("invalid maximum size %q: %w", maxSize, err)
+		}
+	}
+	if filter.MaxSize != 0 && filter.MinSize > filter.MaxSize {
+		return MetadataFilter{}, fmt.Errorf("minimum size %s is larger than maximum size %s", minSize, maxSize)
+	}
+
+	if since != "" {
+		filter.Since, err = parseDate(since, false)
+		if err != nil {
+			return MetadataFilter{}, err
+		}
+	}
+	if until != "" {
+		filter.Until, err = parseDate(until, true)
+		if err != nil {
+			return MetadataFilter{}, err
+		}
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return MetadataFilter{}, fmt.Errorf("since date %s is not before until date %s", since, until)
+	}
+
+	for _, camera := range splitList(cameras) {
+		filter.Cameras = append(filter.Cameras, strings.ToLower(camera))
+	}
+	return filter, nil
+}
+
+// Reports whether the file matches every set criterion.
+func (
+	filter MetadataFilter,
+) Matches(
+	metadata file.Metadata,
+) bool {
+	if len(filter.Types) > 0 {
+		matched := false
+		for _, pattern := range filter.Types {
+			if file.MatchType(pattern, metadata.Path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	size := uint64(metadata.Size)
+	if size < filter.MinSize || (filter.MaxSize != 0 && size > filter.MaxSize) {
+		return false
+	}
+
+	// Dates are compared by their clock time, as the placeholders render them.
+	date := wallClock(metadata.CreationDate)
+	if (!filter.Since.IsZero() && date.Before(filter.Since)) || (!filter.Until.IsZero() && !date.Before(filter.Until)) {
+		return false
+	}
+
+	if len(filter.Cameras) > 0 {
+		camera := strings.ToLower(metadata.Exif.CameraMake + " " + metadata.Exif.CameraModel)
+		matched := false
+		for _, text := range filter.Cameras {
+			if strings.Contains(camera, text) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses a date of any precision as its clock time. For the end of a range
+// the start of the next period is returned.
+func parseDate(
+	value string,
+	end bool,
+) (
+	time.Time,
+	error,
+) {
+	for i, layout := range dateLayouts {
+		date, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if end {
+			switch i {
+			case 0:
+				date = date.Add(time.Second)
+			case 1:
+				date = date.AddDate(0, 0, 1)
+			case 2:
+				date = date.AddDate(0, 1, 0)
+			case 3:
+				date = date.AddDate(1, 0, 0)
+			}
+		}
+		return date, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected a date such as 2024, 2024-03 or 2024-03-15", value)
+}
+
+// Returns the clock time of the date in its own location, as UTC.
+func wallClock(
+	date time.Time,
+) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), 0, time.UTC)
+}
+
+// Splits a comma separated list, leaving out empty values.
+func splitList(
+	value string,
+) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
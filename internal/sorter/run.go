@@ -0,0 +1,569 @@
+package sorter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// What happened to a processed file.
+const (
+	OUTCOME_TRANSFERRED = "transferred"
+	OUTCOME_RENAMED     = "renamed"
+	OUTCOME_OVERWRITTEN = "overwritten"
+	OUTCOME_SKIPPED     = "skipped"
+	// Linked to its original by the duplicate link policy, the source is left
+	// in place.
+	OUTCOME_LINKED = "linked"
+	// Left in place as no copy to another file system could be verified.
+	OUTCOME_FAILED = "failed"
+)
+
+// Totals of a run, reported when it is done.
+type Summary struct {
+	Processed   int
+	Transferred int
+	Collisions  int
+	Renamed     int
+	Overwritten int
+	Skipped     int
+	// Moved files, by rename or by copying them to another file system.
+	MovedByRename int
+	MovedByCopy   int
+	// Files already done by the interrupted run that was resumed.
+	Resumed int
+	// Files with the same contents as another file.
+	Duplicates int
+	// Duplicates linked to their original by the link policy instead of being
+	// transferred, their source is left in place.
+	Linked int
+	// Files left out by the metadata filter while planning.
+	Filtered int
+	// Files whose copies could not be verified, see OUTCOME_FAILED.
+	Failed int
+}
+
+// The result of processing a single file.
+type Result struct {
+	Item    Item
+	Outcome string
+	// Whether a moved file was copied as the destination is on another file
+	// system.
+	CrossDevice bool
+	// Digest of the copied contents, empty when the file was not copied.
+	Hash string
+	// Directories created for the destination, outermost first.
+	Directories []string
+	// The original when the file is a duplicate, and the file it was linked
+	// to by the link policy.
+	Duplicate string
+	Link      string
+	// Why the file failed, see OUTCOME_FAILED.
+	Failure error
+	// Permissions of a moved file before they were changed.
+	Restore *file.Permissions
+	// Whether a symlinked file was copied instead of moved, the link is left
+	// in place.
+	Copied bool
+}
+
+// Processes planned items one by one, keeping track of the destinations
+// claimed so far so collisions within the run are found as well.
+type Run struct {
+	options Options
+	claimed map[string]string
+	journal *Journal
+
+	// Maps duplicates to their original, and transferred files to their
+	// destination.
+	duplicates  map[string]string
+	transferred map[string]string
+	// Directories created for the destination before the run started.
+	created []string
+
+	Summary Summary
+}
+
+func NewRun(
+	options Options,
+) *Run {
+	return &Run{
+		options: options,
+		claimed: map[string]string{},
+
+		duplicates:  map[string]string{},
+		transferred: map[string]string{},
+	}
+}
+
+// Records the directories created for the destination before the run, so
+// undoing the run removes them as well.
+func (
+	run *Run,
+) SetCreatedDirectories(
+	directories []string,
+) {
+	run.created = directories
+}
+
+// Applies the duplicate policy to the duplicates of the groups.
+func (
+	run *Run,
+) SetDuplicates(
+	groups []DuplicateGroup,
+) {
+	for _, group := range groups {
+		for _, duplicate := range group.Duplicates {
+			run.duplicates[duplicate] = group.Original
+		}
+	}
+}
+
+// Removes temporary files left behind by interrupted runs from the directories
+// the items are written to, instead of searching the whole destination. The
+// directories that could not be cleaned are reported in the error, the run can
+// go on without them.
+func (
+	run *Run,
+) RemoveTemporaryFiles(
+	items []Item,
+) (
+	int,
+	error,
+) {
+	options := run.options
+
+	// Files whose destination depends on their hash are copied into the
+	// destination directory first.
+	directories := []string{
+		options.DestDir,
+		filepath.Join(options.DestDir, JOURNAL_DIRECTORY),
+	}
+	for _, item := range items {
+		if item.DestinationPath == "" {
+			continue
+		}
+		directory := filepath.Dir(item.DestinationPath)
+		directories = append(directories, directory)
+
+		if _, duplicate := run.duplicates[item.Path]; duplicate && options.Duplicates == DUPLICATES_MOVE {
+			if relative, err := filepath.Rel(options.DestDir, directory); err == nil {
+				directories = append(directories, filepath.Join(options.DestDir, DUPLICATES_DIRECTORY, relative))
+			}
+		}
+	}
+	slices.Sort(directories)
+	directories = slices.Compact(directories)
+
+	removed := 0
+	var errs []error
+	for _, directory := range directories {
+		count, err := file.RemoveTemporaryFiles(directory)
+		removed += count
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// Transfers the file to its destination, applying the collision policy.
+// In a dry run only the outcome is determined. The returned item has its
+// final destination filled in.
+func (
+	run *Run,
+) Process(
+	item Item,
+) (
+	Result,
+	error,
+) {
+	options := run.options
+	started := time.Now()
+
+	result, err := run.process(item)
+	// A copy that does not match its source only fails the file, the source
+	// is kept and the run continues with the other files.
+	if errors.Is(err, file.ErrVerificationFailed) {
+		run.Summary.Processed++
+		run.Summary.Failed++
+		result.Outcome = OUTCOME_FAILED
+		result.Failure = err
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	run.Summary.Processed++
+	if result.Duplicate != "" {
+		run.Summary.Duplicates++
+	}
+	switch result.Outcome {
+	case OUTCOME_SKIPPED:
+		if result.Duplicate == "" {
+			run.Summary.Collisions++
+		}
+		run.Summary.Skipped++
+	case OUTCOME_RENAMED:
+		run.Summary.Collisions++
+		run.Summary.Renamed++
+	case OUTCOME_OVERWRITTEN:
+		run.Summary.Collisions++
+		run.Summary.Overwritten++
+	}
+	if result.Outcome != OUTCOME_SKIPPED {
+		if result.Link != "" {
+			run.Summary.Linked++
+		} else {
+			run.Summary.Transferred++
+		}
+	}
+	if options.TransferMode() == TRANSFER_MOVE && !options.DryRun && result.Outcome != OUTCOME_SKIPPED && result.Link == "" && !result.Copied {
+		if result.CrossDevice {
+			run.Summary.MovedByCopy++
+		} else {
+			run.Summary.MovedByRename++
+		}
+	}
+	if result.Outcome != OUTCOME_SKIPPED {
+		run.claimed[result.Item.DestinationPath] = result.Item.Path
+		run.transferred[result.Item.Path] = result.Item.DestinationPath
+	}
+
+	if run.journal != nil && !options.DryRun && result.Outcome != OUTCOME_SKIPPED {
+		entry := run.journalEntry(result)
+		entry.Started = started
+		entry.Finished = time.Now()
+		if err := run.journal.Write(entry); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Returns the journal entry describing the result.
+func (
+	run *Run,
+) journalEntry(
+	result Result,
+) JournalEntry {
+	action := run.options.TransferMode()
+	if result.Link != "" {
+		action = TRANSFER_HARDLINK
+	} else if result.Copied {
+		action = TRANSFER_COPY
+	}
+	return JournalEntry{
+		Source:      result.Item.Path,
+		Destination: result.Item.DestinationPath,
+		Original:    result.Link,
+		Action:      action,
+		Outcome:     result.Outcome,
+		Hash:        result.Hash,
+		Size:        result.Item.Size,
+		CrossDevice: result.CrossDevice,
+		Directories: result.Directories,
+		Restore:     result.Restore,
+	}
+}
+
+// Records the operation about to be done, so a resumed run can tell whether
+// it took effect when the run is interrupted before it is recorded as done.
+func (
+	run *Run,
+) writePending(
+	result Result,
+) error {
+	if run.journal == nil {
+		return nil
+	}
+	entry := run.journalEntry(result)
+	entry.Started = time.Now()
+	entry.Pending = true
+	return run.journal.Write(entry)
+}
+
+// Determines the destination and transfers the file, applying the duplicate
+// policy when the file is a duplicate.
+func (
+	run *Run,
+) process(
+	item Item,
+) (
+	Result,
+	error,
+) {
+	options := run.options
+
+	original, duplicate := run.duplicates[item.Path]
+	linkTarget := ""
+	if duplicate && options.Duplicates == DUPLICATES_LINK {
+		// Duplicates of files that were not transferred are transferred
+		// themselves.
+		if destination, ok := run.transferred[original]; ok {
+			linkTarget = destination
+		} else if isWithin(original, options.DestDir) {
+			linkTarget = original
+		} else {
+			duplicate = false
+		}
+	}
+	if duplicate && options.Duplicates == DUPLICATES_SKIP {
+		return Result{
+			Item:      item,
+			Outcome:   OUTCOME_SKIPPED,
+			Duplicate: original,
+		}, nil
+	}
+
+	var err error
+	if item.DestinationPath == "" && (options.TransferMode() != TRANSFER_COPY || options.DryRun || duplicate) {
+		item, err = Resolve(item, options)
+		if err != nil {
+			return Result{Item: item}, err
+		}
+	}
+	if duplicate && options.Duplicates == DUPLICATES_MOVE {
+		relative, err := filepath.Rel(options.DestDir, item.DestinationPath)
+		if err != nil {
+			return Result{Item: item}, err
+		}
+		item.DestinationPath = filepath.Join(options.DestDir, DUPLICATES_DIRECTORY, relative)
+	}
+
+	var result Result
+	if item.DestinationPath == "" {
+		result, err = run.copyDeferred(item)
+	} else {
+		result, err = run.transfer(item, linkTarget)
+	}
+	if duplicate {
+		result.Duplicate = original
+	}
+	return result, err
+}
+
+// Transfers the file to its destination, or links the destination to the
+// link target when given.
+func (
+	run *Run,
+) transfer(
+	item Item,
+	linkTarget string,
+) (
+	Result,
+	error,
+) {
+	outcome, err := run.resolveCollision(&item)
+	if outcome == OUTCOME_TRANSFERRED && linkTarget != "" {
+		outcome = OUTCOME_LINKED
+	}
+	result := Result{
+		Item:    item,
+		Outcome: outcome,
+		Link:    linkTarget,
+		// Symlinks are sorted by their target, which is copied the same way
+		// whether or not it is on the same file system.
+		Copied: linkTarget == "" && run.options.TransferMode() == TRANSFER_MOVE && isSymlink(item.Path),
+	}
+	if err != nil || outcome == OUTCOME_SKIPPED || run.options.DryRun {
+		return result, err
+	}
+
+	result.Directories, err = CreateDirectories(filepath.Dir(item.DestinationPath), run.options.Permissions)
+	if err != nil {
+		return result, err
+	}
+
+	mode := run.options.TransferMode()
+	if linkTarget != "" {
+		mode = TRANSFER_HARDLINK
+	} else if result.Copied {
+		mode = TRANSFER_COPY
+	}
+	// The moved file itself gets the permissions, undo sets the old ones again.
+	if mode == TRANSFER_MOVE && run.options.Permissions != (file.Permissions{}) {
+		info, err := os.Lstat(item.Path)
+		if err != nil {
+			return result, err
+		}
+		// Only what is changed is restored.
+		permissions := file.FilePermissions(info)
+		permissions.SetOwner = permissions.SetOwner && run.options.Permissions.SetOwner
+		permissions.SetGroup = permissions.SetGroup && run.options.Permissions.SetGroup
+		if run.options.Permissions.FileMode == 0 {
+			permissions.FileMode = 0
+		}
+		result.Restore = &permissions
+	}
+	if err := run.writePending(result); err != nil {
+		return result, err
+	}
+	// Links share the file of their target, which is left as is.
+	switch mode {
+	case TRANSFER_MOVE:
+		// The digest of a copy to another file system, otherwise the one
+		// computed for the format, lets undo check the file is unchanged.
+		result.Hash = item.Hashes[file.HASH_DEFAULT]
+		var digest string
+		result.CrossDevice, digest, err = file.MoveFile(item.Path, item.DestinationPath, run.options.CopyMode, run.options.Permissions)
+		if digest != "" {
+			result.Hash = digest
+		}
+	case TRANSFER_HARDLINK:
+		if linkTarget != "" {
+			err = file.HardlinkFile(linkTarget, item.DestinationPath)
+		} else {
+			err = file.HardlinkFile(item.Path, item.DestinationPath)
+		}
+	case TRANSFER_SYMLINK:
+		err = file.SymlinkFile(item.Path, item.DestinationPath, false)
+	case TRANSFER_SYMLINK_RELATIVE:
+		err = file.SymlinkFile(item.Path, item.DestinationPath, true)
+	default:
+		var temporaryPath string
+		var digests map[string]string
+		temporaryPath, digests, err = file.CopyToTemporary(item.Path, filepath.Dir(item.DestinationPath), run.options.copyOptions(), file.HASH_DEFAULT)
+		if err != nil {
+			return result, err
+		}
+		result.Hash = digests[file.HASH_DEFAULT]
+		if err := run.options.Permissions.Apply(temporaryPath, false); err != nil {
+			os.Remove(temporaryPath)
+			return result, err
+		}
+		err = file.CommitFile(temporaryPath, item.DestinationPath)
+	}
+	return result, err
+}
+
+// Copies the file to a temporary file in the destination directory while
+// hashing it, then renames it to the destination determined by the hashes.
+// Skipped files leave no temporary file behind.
+func (
+	run *Run,
+) copyDeferred(
+	item Item,
+) (
+	Result,
+	error,
+) {
+	options := run.options
+	algorithms := options.formatFor(item.Path).HashAlgorithms()
+	if !slices.Contains(algorithms, file.HASH_DEFAULT) {
+		algorithms = append(slices.Clone(algorithms), file.HASH_DEFAULT)
+	}
+
+	temporaryPath, hashes, err := file.CopyToTemporary(item.Path, options.DestDir, options.copyOptions(), algorithms...)
+	if err != nil {
+		return Result{Item: item}, err
+	}
+	defer os.Remove(temporaryPath)
+	item.Hashes = hashes
+
+	item.DestinationPath, err = destinationPath(options, item)
+	if err != nil {
+		return Result{Item: item}, err
+	}
+
+	outcome, err := run.resolveCollision(&item)
+	result := Result{
+		Item:    item,
+		Outcome: outcome,
+		Hash:    hashes[file.HASH_DEFAULT],
+	}
+	if err != nil || outcome == OUTCOME_SKIPPED {
+		return result, err
+	}
+
+	result.Directories, err = CreateDirectories(filepath.Dir(item.DestinationPath), options.Permissions)
+	if err != nil {
+		return result, err
+	}
+	if err := options.Permissions.Apply(temporaryPath, false); err != nil {
+		return result, err
+	}
+	if err := run.writePending(result); err != nil {
+		return result, err
+	}
+	return result, file.CommitFile(temporaryPath, item.DestinationPath)
+}
+
+// Creates the directory and its missing parents, returning the directories
+// that were created, outermost first. The permissions are applied to the
+// created directories.
+func CreateDirectories(
+	directory string,
+	permissions file.Permissions,
+) (
+	[]string,
+	error,
+) {
+	var missing []string
+	for path := directory; ; path = filepath.Dir(path) {
+		if _, err := os.Lstat(path); err == nil {
+			break
+		}
+		missing = append([]string{path}, missing...)
+		if filepath.Dir(path) == path {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", directory, err)
+	}
+	for _, path := range missing {
+		if err := permissions.Apply(path, true); err != nil {
+			return missing, err
+		}
+	}
+	return missing, nil
+}
+
+// Removes the source directories emptied by moving their files, deepest
+// first. Directories that still hold files, such as skipped ones, are kept and
+// so is the source directory itself. Returns the removed directories.
+func (
+	run *Run,
+) PruneEmpty() []string {
+	if run.options.TransferMode() != TRANSFER_MOVE || run.options.DryRun {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var directories []string
+	for source := range run.transferred {
+		for directory := filepath.Dir(source); directory != run.options.SourceDir && isWithin(directory, run.options.SourceDir); directory = filepath.Dir(directory) {
+			if seen[directory] {
+				break
+			}
+			seen[directory] = true
+			directories = append(directories, directory)
+		}
+	}
+	slices.SortFunc(directories, func(a string, b string) int {
+		return strings.Count(b, string(filepath.Separator)) - strings.Count(a, string(filepath.Separator))
+	})
+
+	var removed []string
+	for _, directory := range directories {
+		// Followed symlinks are walked as directories, but removing one would
+		// unlink it while its target still holds files.
+		info, err := os.Lstat(directory)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if err := os.Remove(directory); err == nil {
+			removed = append(removed, directory)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,155 @@
+package sorter
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// Maximum number of differing bits for images to count as near-duplicates.
+const PERCEPTUAL_THRESHOLD_DEFAULT = 10
+
+// Directory near-duplicates are moved to when only the best image of each
+// group is kept.
+const NEAR_DUPLICATES_DIRECTORY = "near-duplicates"
+
+// An image and its perceptual hash.
+type SimilarImage struct {
+	Path string
+	file.PerceptualHash
+}
+
+// Images that look alike, the one with the highest resolution first.
+type SimilarGroup struct {
+	Images []SimilarImage
+}
+
+// Hashes the JPEG, PNG and GIF images in the directory and groups those whose
+// hashes differ in at most the threshold number of bits. Images that are
+// similar through another image end up in the same group. Returns the groups
+// and the images that could not be decoded.
+func FindSimilarImages(
+	directory string,
+	algorithm string,
+	threshold int,
+) (
+	[]SimilarGroup,
+	[]string,
+	error,
+) {
+	var images []SimilarImage
+	var undecodable []string
+	err := filepath.WalkDir(
+		directory,
+		func(
+			path string,
+			entry fs.DirEntry,
+			err error,
+		) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if path != directory && (entry.Name() == JOURNAL_DIRECTORY || entry.Name() == NEAR_DUPLICATES_DIRECTORY) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() || !file.IsDecodableImage(path) {
+				return nil
+			}
+
+			hash, err := file.HashImage(path, algorithm)
+			if err != nil {
+				undecodable = append(undecodable, path)
+				return nil
+			}
+			images = append(images, SimilarImage{
+				Path:           path,
+				PerceptualHash: hash,
+			})
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Union-find over every pair within the threshold.
+	parents := make([]int, len(images))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			if file.HammingDistance(images[i].Hash, images[j].Hash) <= threshold {
+				parents[find(j)] = find(i)
+			}
+		}
+	}
+
+	members := map[int][]SimilarImage{}
+	for i, similarImage := range images {
+		root := find(i)
+		members[root] = append(members[root], similarImage)
+	}
+
+	var groups []SimilarGroup
+	for _, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		slices.SortStableFunc(group, func(a SimilarImage, b SimilarImage) int {
+			return b.Width*b.Height - a.Width*a.Height
+		})
+		groups = append(groups, SimilarGroup{Images: group})
+	}
+	slices.SortFunc(groups, func(a SimilarGroup, b SimilarGroup) int {
+		return strings.Compare(a.Images[0].Path, b.Images[0].Path)
+	})
+	return groups, undecodable, nil
+}
+
+// Moves every image but the one with the highest resolution into the
+// near-duplicates directory, keeping their path relative to the directory.
+// Returns the new paths of the moved images.
+func KeepBest(
+	group SimilarGroup,
+	directory string,
+) (
+	[]string,
+	error,
+) {
+	var moved []string
+	for _, similarImage := range group.Images[1:] {
+		relative, err := filepath.Rel(directory, similarImage.Path)
+		if err != nil {
+			return moved, err
+		}
+		destination := filepath.Join(directory, NEAR_DUPLICATES_DIRECTORY, relative)
+		if _, err := os.Lstat(destination); err == nil {
+			return moved, fmt.Errorf("destination %s already exists", destination)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(destination), os.ModePerm); err != nil {
+			return moved, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(destination), err)
+		}
+		if _, _, err := file.MoveFile(similarImage.Path, destination, file.COPY_MODE_AUTO, file.Permissions{}); err != nil {
+			return moved, err
+		}
+		moved = append(moved, destination)
+	}
+	return moved, nil
+}
@@ -0,0 +1,260 @@
+package sorter
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+	place "github.com/redkenrok/go-file_sorter/internal/place"
+)
+
+// Settings of a single sorting run, shared by the CLI and TUI.
+type Options struct {
+	SourceDir string
+	DestDir   string
+	Format    *file.Template
+	Transfer  string
+	DryRun    bool
+
+	FormatRules []file.FormatRule
+	Sanitizer   file.Sanitizer
+	Places      place.Places
+	Collision   string
+	Preserve    file.Preservation
+	CopyMode    string
+	Duplicates  string
+	Permissions file.Permissions
+	Include     []PathPattern
+	Exclude     []PathPattern
+	Filter      MetadataFilter
+
+	// How the source directory is walked, see walkSource.
+	FollowSymlinks bool
+	Hidden         string
+	MaxDepth       int
+	OneFileSystem  bool
+}
+
+// Returns the template used for the file.
+func (
+	options Options,
+) formatFor(
+	path string,
+) *file.Template {
+	return file.SelectFormat(options.FormatRules, options.Format, path)
+}
+
+// Returns the transfer mode, copying when none is set.
+func (
+	options Options,
+) TransferMode() string {
+	if options.Transfer == "" {
+		return TRANSFER_COPY
+	}
+	return options.Transfer
+}
+
+// Returns how files are copied.
+func (
+	options Options,
+) copyOptions() file.CopyOptions {
+	return file.CopyOptions{
+		Preserve: options.Preserve,
+		Mode:     options.CopyMode,
+	}
+}
+
+// A file found in the source directory and where it will be sorted to.
+type Item struct {
+	file.Metadata
+	DestinationPath string
+}
+
+// Walks the source directory and determines the destination of every file
+// that is not left out by the ignore files and patterns. Files not matching
+// the metadata filter are left out as well and returned as the filtered count.
+// When the format contains hashes and the files are copied the destination is
+// left empty, it is determined by Run.Process while copying so every file is only
+// read once. The rest of its path is still checked up front.
+func Plan(
+	options Options,
+) (
+	[]Item,
+	int,
+	error,
+) {
+	var items []Item
+	filtered := 0
+	filter := newPathFilter(options)
+	err := walkSource(
+		options,
+		func(
+			path string,
+			info fs.FileInfo,
+			err error,
+		) error {
+			if err != nil {
+				return err
+			}
+
+			if skip, err := filter.skip(path, info); skip {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			// Skip files in destination directory.
+			absPath, _ := filepath.Abs(path)
+			if isWithin(absPath, options.DestDir) {
+				return nil
+			}
+
+			exifData, _ := file.GetFileExif(path)
+			creationDate, err := file.GetFileCreationDate(path, exifData)
+			if err != nil {
+				return fmt.Errorf("error getting creation date for file %s: %w", path, err)
+			}
+
+			// Symlinks are sorted by their target, so is their size.
+			size := info.Size()
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if target, err := os.Stat(path); err == nil {
+					size = target.Size()
+				}
+			}
+
+			placeName := ""
+			if exifData.HasLocation {
+				placeName, _ = options.Places.Match(exifData.Latitude, exifData.Longitude)
+			}
+
+			item := Item{
+				Metadata: file.Metadata{
+					Path:         path,
+					Index:        len(items) + 1,
+					CreationDate: creationDate,
+					Place:        placeName,
+					Size:         size,
+					Exif:         exifData,
+				},
+			}
+			if !options.Filter.Matches(item.Metadata) {
+				filtered++
+				return nil
+			}
+
+			algorithms := options.formatFor(path).HashAlgorithms()
+			if len(algorithms) > 0 && options.TransferMode() == TRANSFER_COPY && !options.DryRun {
+				// Check the path with stand-in digests, hex can not escape it.
+				placeholderItem := item
+				placeholderItem.Hashes = map[string]string{}
+				for _, algorithm := range algorithms {
+					placeholderItem.Hashes[algorithm] = "0"
+				}
+				if _, err := destinationPath(options, placeholderItem); err != nil {
+					return err
+				}
+			} else {
+				item, err = Resolve(item, options)
+				if err != nil {
+					return err
+				}
+			}
+
+			items = append(items, item)
+			return nil
+		},
+	)
+	return items, filtered, err
+}
+
+// Determines the destination of an item planned without one by hashing it up
+// front.
+func Resolve(
+	item Item,
+	options Options,
+) (
+	Item,
+	error,
+) {
+	if item.DestinationPath != "" {
+		return item, nil
+	}
+
+	algorithms := options.formatFor(item.Path).HashAlgorithms()
+	if len(algorithms) > 0 {
+		var err error
+		item.Hashes, err = file.HashFile(item.Path, algorithms)
+		if err != nil {
+			return item, fmt.Errorf("error hashing file %s: %w", item.Path, err)
+		}
+	}
+
+	var err error
+	item.DestinationPath, err = destinationPath(options, item)
+	return item, err
+}
+
+func destinationPath(
+	options Options,
+	item Item,
+) (
+	string,
+	error,
+) {
+	newFileName, err := file.FormatName(options.formatFor(item.Path), item.Metadata)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(options.DestDir, options.Sanitizer.Sanitize(newFileName)), nil
+}
+
+// Renders the destinations of planned items again after the options changed,
+// without probing the files again.
+func RenderDestinations(
+	items []Item,
+	options Options,
+) (
+	[]Item,
+	error,
+) {
+	rendered := make([]Item, len(items))
+	for i, item := range items {
+		if item.DestinationPath != "" {
+			var err error
+			item.DestinationPath, err = destinationPath(options, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		rendered[i] = item
+	}
+	return rendered, nil
+}
+
+// Counts the number of files per matched place. Files outside of any place
+// are counted under an empty name.
+func CountPlaces(
+	items []Item,
+) map[string]int {
+	counts := map[string]int{}
+	for _, item := range items {
+		counts[item.Place]++
+	}
+	return counts
+}
+
+func isWithin(
+	path string,
+	dir string,
+) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,103 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// Share of the required space kept free on top of it, and the space kept free
+// for every file, as metadata and directories take up space as well.
+const (
+	SPACE_MARGIN_RATIO    = 0.05
+	SPACE_MARGIN_PER_FILE = 4 << 10
+)
+
+// The space a run needs in the destination and the space available there.
+type SpaceCheck struct {
+	Required  uint64
+	Available uint64
+}
+
+// Reports whether the destination has room for the run.
+func (
+	check SpaceCheck,
+) Sufficient() bool {
+	return check.Required <= check.Available
+}
+
+func (
+	check SpaceCheck,
+) String() string {
+	return fmt.Sprintf("%s required, %s available", humanize.Bytes(check.Required), humanize.Bytes(check.Available))
+}
+
+// Computes the space the items need in the destination directory, including
+// a safety margin. Links take no space and neither do moves renamed within the
+// same file system, duplicates skipped or linked by the duplicate policy and
+// files skipped as their destination exists. Files that are only skipped when
+// they are identical to the existing file are counted.
+func (
+	run *Run,
+) CheckSpace(
+	items []Item,
+) (
+	SpaceCheck,
+	error,
+) {
+	options := run.options
+	var required uint64
+	for _, item := range items {
+		switch options.TransferMode() {
+		case TRANSFER_HARDLINK, TRANSFER_SYMLINK, TRANSFER_SYMLINK_RELATIVE:
+			continue
+		case TRANSFER_MOVE:
+			if file.SameDevice(item.Path, options.DestDir) && !isSymlink(item.Path) {
+				continue
+			}
+		}
+		if _, duplicate := run.duplicates[item.Path]; duplicate && (options.Duplicates == DUPLICATES_SKIP || options.Duplicates == DUPLICATES_LINK) {
+			continue
+		}
+		if run.skipsExisting(item) {
+			continue
+		}
+		required += uint64(item.Size) + SPACE_MARGIN_PER_FILE
+	}
+	required += uint64(float64(required) * SPACE_MARGIN_RATIO)
+
+	available, err := file.FreeSpace(options.DestDir)
+	if err != nil {
+		return SpaceCheck{}, err
+	}
+	return SpaceCheck{
+		Required:  required,
+		Available: available,
+	}, nil
+}
+
+// Reports whether the collision policy skips the item because its planned
+// destination already exists.
+func (
+	run *Run,
+) skipsExisting(
+	item Item,
+) bool {
+	if item.DestinationPath == "" {
+		return false
+	}
+	existing, err := os.Stat(item.DestinationPath)
+	if err != nil {
+		return false
+	}
+	switch run.options.Collision {
+	case COLLISION_SKIP:
+		return true
+	case COLLISION_KEEP_NEWER:
+		source, err := os.Stat(item.Path)
+		return err == nil && !source.ModTime().After(existing.ModTime())
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package sorter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// Name of the file in the journal directory holding the state of an
+// unfinished run.
+const STATE_FILE = "state.json"
+
+// The state of a run, saved before the first file is transferred and removed
+// once the run finishes. Together with the journal it tells which files are
+// done when an interrupted run is resumed.
+type State struct {
+	SourceDir   string   `json:"source_dir"`
+	Format      string   `json:"format"`
+	FormatRules []string `json:"format_rules,omitempty"`
+	Sanitize    string   `json:"sanitize"`
+	Replacement string   `json:"replacement"`
+	Normalize   string   `json:"normalize"`
+	Collision   string   `json:"collision"`
+	Transfer    string   `json:"transfer"`
+	Journal     string   `json:"journal"`
+	Items       []Item   `json:"items"`
+}
+
+// Returns the format rules as pattern=format, in the order they were given.
+func formatRules(
+	options Options,
+) []string {
+	var rules []string
+	for _, rule := range options.FormatRules {
+		rules = append(rules, rule.Pattern+"="+rule.Template.String())
+	}
+	return rules
+}
+
+func statePath(
+	destDir string,
+) string {
+	return filepath.Join(destDir, JOURNAL_DIRECTORY, STATE_FILE)
+}
+
+// Whether the destination holds the state of an unfinished run.
+func HasState(
+	destDir string,
+) bool {
+	_, err := os.Stat(statePath(destDir))
+	return err == nil
+}
+
+// Starts the journal and saves the planned items, so the run can be resumed
+// when it is interrupted. Returns the path of the journal.
+func (
+	run *Run,
+) Start(
+	items []Item,
+) (
+	string,
+	error,
+) {
+	journalPath, err := run.startJournal()
+	if err != nil {
+		return "", err
+	}
+
+	state := State{
+		SourceDir:   run.options.SourceDir,
+		Format:      run.options.Format.String(),
+		FormatRules: formatRules(run.options),
+		Sanitize:    run.options.Sanitizer.Profile,
+		Replacement: run.options.Sanitizer.Replacement,
+		Normalize:   run.options.Sanitizer.Normalization,
+		Collision:   run.options.Collision,
+		Transfer:    run.options.TransferMode(),
+		Journal:     journalPath,
+		Items:       items,
+	}
+	contents, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("failed to save run state: %w", err)
+	}
+
+	path := statePath(run.options.DestDir)
+	temporaryFile, err := os.CreateTemp(filepath.Dir(path), file.TEMPORARY_PATTERN)
+	if err != nil {
+		return "", fmt.Errorf("failed to save run state: %w", err)
+	}
+	_, err = temporaryFile.Write(contents)
+	if err == nil {
+		err = temporaryFile.Sync()
+	}
+	if err == nil {
+		err = run.options.Permissions.Apply(temporaryFile.Name(), false)
+	}
+	if closeErr := temporaryFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporaryFile.Name())
+		return "", fmt.Errorf("failed to save run state: %w", err)
+	}
+	if err := file.CommitFile(temporaryFile.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to save run state: %w", err)
+	}
+	return journalPath, nil
+}
+
+// Loads the state of the interrupted run in the destination and continues its
+// journal. Returns the planned items that are not done yet, with the indices
+// of the original plan. Items the journal lists as done are only skipped when
+// their destination is still in place.
+func (
+	run *Run,
+) Resume() (
+	[]Item,
+	string,
+	error,
+) {
+	contents, err := os.ReadFile(statePath(run.options.DestDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("no interrupted run to resume in %s", run.options.DestDir)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load run state: %w", err)
+	}
+	var state State
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return nil, "", fmt.Errorf("failed to load run state: %w", err)
+	}
+
+	switch {
+	case state.SourceDir != run.options.SourceDir:
+		return nil, "", fmt.Errorf("the interrupted run sorted %s, not %s", state.SourceDir, run.options.SourceDir)
+	case state.Format != run.options.Format.String():
+		return nil, "", fmt.Errorf("the interrupted run used the format %q, not %q", state.Format, run.options.Format.String())
+	case !slices.Equal(state.FormatRules, formatRules(run.options)):
+		return nil, "", fmt.Errorf("the interrupted run used the format rules %q, not %q", state.FormatRules, formatRules(run.options))
+	case state.Sanitize != run.options.Sanitizer.Profile:
+		return nil, "", fmt.Errorf("the interrupted run sanitized names for %s, not %s", state.Sanitize, run.options.Sanitizer.Profile)
+	case state.Replacement != run.options.Sanitizer.Replacement:
+		return nil, "", fmt.Errorf("the interrupted run used the replacement %q, not %q", state.Replacement, run.options.Sanitizer.Replacement)
+	case state.Normalize != run.options.Sanitizer.Normalization:
+		return nil, "", fmt.Errorf("the interrupted run used the normalization %s, not %s", state.Normalize, run.options.Sanitizer.Normalization)
+	case state.Collision != run.options.Collision:
+		return nil, "", fmt.Errorf("the interrupted run used the collision policy %s, not %s", state.Collision, run.options.Collision)
+	case state.Transfer != run.options.TransferMode():
+		return nil, "", fmt.Errorf("the interrupted run used the transfer mode %s, not %s", state.Transfer, run.options.TransferMode())
+	}
+
+	entries, err := ReadJournal(state.Journal)
+	if err != nil {
+		return nil, "", err
+	}
+	done := map[string]JournalEntry{}
+	for _, entry := range entries {
+		done[entry.Source] = entry
+	}
+
+	if err := run.resumeJournal(state.Journal); err != nil {
+		return nil, "", err
+	}
+
+	var remaining []Item
+	for _, item := range state.Items {
+		entry, ok := done[item.Path]
+		if ok && entry.Pending {
+			ok, err = run.settle(entry)
+			if err != nil {
+				run.Close()
+				return nil, "", err
+			}
+		} else if ok {
+			ok = isDone(entry, item)
+		}
+		if ok {
+			run.claimed[entry.Destination] = entry.Source
+			run.transferred[entry.Source] = entry.Destination
+			run.Summary.Resumed++
+			continue
+		}
+		remaining = append(remaining, item)
+	}
+	return remaining, state.Journal, nil
+}
+
+// Finishes the operation of a pending entry that took effect before the run
+// was interrupted and records it as done. Reports false when the operation
+// has to be done again.
+func (
+	run *Run,
+) settle(
+	entry JournalEntry,
+) (
+	bool,
+	error,
+) {
+	settled, ok := SettleEntry(entry)
+	if !ok {
+		return false, nil
+	}
+	// The copy of an interrupted move is in place, only the source is left.
+	if entry.Action == TRANSFER_MOVE && settled.Action == TRANSFER_COPY {
+		if err := os.Remove(entry.Source); err != nil {
+			return false, fmt.Errorf("failed to finish moving %s: %w", entry.Source, err)
+		}
+		settled.Action = TRANSFER_MOVE
+		settled.Hash = ""
+		settled.CrossDevice = true
+	}
+	settled.Finished = time.Now()
+	return true, run.journal.Write(settled)
+}
+
+// Cheaply checks whether the operation in the journal is still in effect: the
+// destination exists with the size of the file, and a moved source is gone.
+func isDone(
+	entry JournalEntry,
+	item Item,
+) bool {
+	info, err := os.Stat(entry.Destination)
+	if err != nil || info.Size() != item.Size {
+		return false
+	}
+	if entry.Action == TRANSFER_MOVE {
+		if _, err := os.Lstat(entry.Source); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Closes the journal and removes the run state, as there is nothing left to
+// resume. Runs without a journal, such as dry runs, leave the state alone.
+func (
+	run *Run,
+) Finish() error {
+	if run.journal == nil {
+		return nil
+	}
+	if err := run.Close(); err != nil {
+		return err
+	}
+	err := os.Remove(statePath(run.options.DestDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove run state: %w", err)
+	}
+	return nil
+}
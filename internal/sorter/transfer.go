@@ -0,0 +1,87 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// How files end up at their destination.
+const (
+	TRANSFER_COPY             = "copy"
+	TRANSFER_MOVE             = "move"
+	TRANSFER_HARDLINK         = "hardlink"
+	TRANSFER_SYMLINK          = "symlink"
+	TRANSFER_SYMLINK_RELATIVE = "relative-symlink"
+)
+
+var transferModes = []string{
+	TRANSFER_COPY,
+	TRANSFER_MOVE,
+	TRANSFER_HARDLINK,
+	TRANSFER_SYMLINK,
+	TRANSFER_SYMLINK_RELATIVE,
+}
+
+// The link types accepted by --link and the transfer mode they select.
+var linkTypes = map[string]string{
+	"hard":     TRANSFER_HARDLINK,
+	"symlink":  TRANSFER_SYMLINK,
+	"relative": TRANSFER_SYMLINK_RELATIVE,
+}
+
+// Returns the available transfer modes, the first is the default.
+func GetTransferModes() []string {
+	return transferModes
+}
+
+// Returns the transfer mode for a link type: hard, symlink or relative.
+func ParseLinkType(
+	value string,
+) (
+	string,
+	error,
+) {
+	mode, ok := linkTypes[value]
+	if !ok {
+		types := make([]string, 0, len(linkTypes))
+		for linkType := range linkTypes {
+			types = append(types, linkType)
+		}
+		slices.Sort(types)
+		return "", fmt.Errorf("unknown link type %q, expected one of %s", value, strings.Join(types, ", "))
+	}
+	return mode, nil
+}
+
+// Fails when hard links are made and a file is on another file system than
+// the destination, so the run is refused before anything is written to it.
+func CheckHardlinks(
+	items []Item,
+	options Options,
+) error {
+	if options.TransferMode() != TRANSFER_HARDLINK {
+		return nil
+	}
+
+	// Dry runs do not create the destination, its closest existing parent is
+	// on the same file system.
+	destination := options.DestDir
+	for {
+		if _, err := os.Stat(destination); err == nil || filepath.Dir(destination) == destination {
+			break
+		}
+		destination = filepath.Dir(destination)
+	}
+
+	for _, item := range items {
+		if !file.SameDevice(item.Path, destination) {
+			return fmt.Errorf("can not hardlink %s as it is on another file system than %s", item.Path, options.DestDir)
+		}
+	}
+	return nil
+}
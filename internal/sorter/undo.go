@@ -0,0 +1,131 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// Reverses the operation of a journal entry. Moved files are moved back,
+// copies and links are removed once they are verified to still be what the
+// run created. A file that changed since is kept and an error is returned.
+func UndoEntry(
+	entry JournalEntry,
+) error {
+	info, err := os.Lstat(entry.Destination)
+	if err != nil {
+		return fmt.Errorf("destination %s is gone: %w", entry.Destination, err)
+	}
+
+	switch entry.Action {
+	case TRANSFER_MOVE:
+		if _, err := os.Lstat(entry.Source); err == nil {
+			return fmt.Errorf("source %s exists again, not moving %s back", entry.Source, entry.Destination)
+		}
+		if err := unchanged(entry); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(entry.Source), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(entry.Source), err)
+		}
+		_, _, err := file.MoveFile(entry.Destination, entry.Source, file.COPY_MODE_AUTO, file.Permissions{})
+		if err == nil && entry.Restore != nil {
+			err = entry.Restore.Apply(entry.Source, false)
+		}
+		return err
+
+	case TRANSFER_HARDLINK:
+		target := entry.Source
+		if entry.Original != "" {
+			target = entry.Original
+		}
+		targetInfo, err := os.Stat(target)
+		if err != nil || !os.SameFile(info, targetInfo) {
+			return fmt.Errorf("%s is no longer a link to %s, keeping it", entry.Destination, target)
+		}
+
+	case TRANSFER_SYMLINK, TRANSFER_SYMLINK_RELATIVE:
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s is no longer a link, keeping it", entry.Destination)
+		}
+
+	default:
+		if entry.Hash == "" {
+			return fmt.Errorf("no hash recorded for %s, keeping it", entry.Destination)
+		}
+		if err := unchanged(entry); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(entry.Destination); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", entry.Destination, err)
+	}
+	return nil
+}
+
+// Checks the destination still has the recorded digest, or the recorded size
+// when the file was moved without hashing it.
+func unchanged(
+	entry JournalEntry,
+) error {
+	if entry.Hash == "" {
+		info, err := os.Stat(entry.Destination)
+		if err != nil {
+			return err
+		}
+		if entry.Size != 0 && info.Size() != entry.Size {
+			return fmt.Errorf("%s changed since it was %s, keeping it", entry.Destination, transferVerb(entry.Action))
+		}
+		return nil
+	}
+
+	digests, err := file.HashFile(entry.Destination, []string{file.HASH_DEFAULT})
+	if err != nil {
+		return err
+	}
+	if digests[file.HASH_DEFAULT] != entry.Hash {
+		return fmt.Errorf("%s changed since it was %s, keeping it", entry.Destination, transferVerb(entry.Action))
+	}
+	return nil
+}
+
+func transferVerb(
+	action string,
+) string {
+	if action == TRANSFER_MOVE {
+		return "moved"
+	}
+	return "copied"
+}
+
+// Removes the directories created by the run that are empty again, deepest
+// first, and returns the removed directories.
+func RemoveCreatedDirectories(
+	entries []JournalEntry,
+) []string {
+	var directories []string
+	for _, entry := range entries {
+		for _, directory := range entry.Directories {
+			if !slices.Contains(directories, directory) {
+				directories = append(directories, directory)
+			}
+		}
+	}
+	slices.SortFunc(directories, func(a string, b string) int {
+		return strings.Count(b, string(filepath.Separator)) - strings.Count(a, string(filepath.Separator))
+	})
+
+	// Directories that are not empty hold other files since and are kept.
+	var removed []string
+	for _, directory := range directories {
+		if err := os.Remove(directory); err == nil {
+			removed = append(removed, directory)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,169 @@
+package sorter
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	file "github.com/redkenrok/go-file_sorter/internal/file"
+)
+
+// Whether hidden files and directories, those starting with a dot, are sorted.
+const (
+	HIDDEN_INCLUDE = "include"
+	HIDDEN_EXCLUDE = "exclude"
+)
+
+var hiddenPolicies = []string{
+	HIDDEN_INCLUDE,
+	HIDDEN_EXCLUDE,
+}
+
+// Returns the ways hidden files can be handled.
+func GetHiddenPolicies() []string {
+	return hiddenPolicies
+}
+
+func ParseHiddenPolicy(
+	value string,
+) (
+	string,
+	error,
+) {
+	for _, policy := range hiddenPolicies {
+		if value == policy {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hidden policy %q, expected one of %s", value, strings.Join(hiddenPolicies, ", "))
+}
+
+// Walks the source directory, see walkSource.
+type sourceWalker struct {
+	options Options
+	// Resolved paths of the walked directories.
+	visited map[string]bool
+}
+
+// Walks the source directory like filepath.Walk, calling the function for
+// every directory and file in lexical order. Symlinks are followed when set,
+// walking every directory once so cycles end, otherwise links to directories
+// are left out. Hidden paths, directories deeper
+// than the maximum depth and directories on other file systems are left out
+// when set.
+func walkSource(
+	options Options,
+	walkFunction filepath.WalkFunc,
+) error {
+	root := options.SourceDir
+	info, err := os.Stat(root)
+	if err != nil {
+		return walkFunction(root, nil, err)
+	}
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return walkFunction(root, info, err)
+	}
+
+	walker := &sourceWalker{
+		options: options,
+		visited: map[string]bool{},
+	}
+	err = walker.walk(root, resolved, info, 0, walkFunction)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (
+	walker *sourceWalker,
+) walk(
+	path string,
+	resolved string,
+	info fs.FileInfo,
+	depth int,
+	walkFunction filepath.WalkFunc,
+) error {
+	if !info.IsDir() {
+		return walkFunction(path, info, nil)
+	}
+
+	if walker.visited[resolved] {
+		return nil
+	}
+	walker.visited[resolved] = true
+
+	if err := walkFunction(path, info, nil); err != nil {
+		return err
+	}
+	if walker.options.MaxDepth > 0 && depth >= walker.options.MaxDepth {
+		return nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return walkFunction(path, info, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if walker.options.Hidden == HIDDEN_EXCLUDE && strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		childPath := filepath.Join(path, name)
+		childResolved := filepath.Join(resolved, name)
+		childInfo, err := entry.Info()
+		if err != nil {
+			if err := walkFunction(childPath, nil, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		// Links to directories are left out unless followed, broken links are
+		// passed on as they are.
+		if childInfo.Mode()&fs.ModeSymlink != 0 {
+			if target, err := os.Stat(childPath); err == nil {
+				if !walker.options.FollowSymlinks {
+					if target.IsDir() {
+						continue
+					}
+				} else {
+					childInfo = target
+					childResolved, err = filepath.EvalSymlinks(childPath)
+					if err != nil {
+						return walkFunction(childPath, childInfo, err)
+					}
+				}
+			}
+		}
+
+		if childInfo.IsDir() && walker.options.OneFileSystem && !file.SameDevice(walker.options.SourceDir, childPath) {
+			continue
+		}
+
+		err = walker.walk(childPath, childResolved, childInfo, depth+1, walkFunction)
+		if err != nil {
+			// Skipping a file skips the rest of the directory.
+			if err == filepath.SkipDir {
+				if childInfo.IsDir() {
+					continue
+				}
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Reports whether the path itself is a symlink.
+func isSymlink(
+	path string,
+) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
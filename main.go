@@ -15,11 +15,20 @@ var (
 
 func main() {
 	flag.Usage = func() {
-		fmt.Println("For CLI usage run: `file_sorter --help`.\n")
-		fmt.Println("For TUI usage run without options.\n")
+		fmt.Print("For CLI usage run: `file_sorter --help`.\n\n")
+		fmt.Print("For TUI usage run without options.\n\n")
 	}
 	flag.Parse()
 
+	switch flag.Arg(0) {
+	case "undo":
+		app.RunUndo(flag.Args()[1:])
+		return
+	case "dedupe":
+		app.RunDedupe(flag.Args()[1:])
+		return
+	}
+
 	if flag.NFlag() == 0 {
 		app.RunTUI(
 			version,